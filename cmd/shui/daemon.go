@@ -0,0 +1,69 @@
+//
+// Running Shui in the background.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	DAEMON_ENV_VAR          = "SHUI_DAEMON" // Set for Shuis started in the background.
+	DEFAULT_SOCKET_DIR      = "shui"        // Directory in user cache for sockets.
+	DEFAULT_SOCKET_FILENAME = "shui.sock"   // Socket to serve results on in the background.
+)
+
+// Determines whether this is the background Shui started by `daemonize`.
+func isDaemon() bool {
+	return os.Getenv(DAEMON_ENV_VAR) != ""
+}
+
+// Provides the socket used by Shuis in the background, when no other is provided.
+func defaultSocket() (socket string, err error) {
+	var (
+		userCacheDir string // User cache directory, contextual to OS.
+	)
+
+	if userCacheDir, err = os.UserCacheDir(); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(userCacheDir, DEFAULT_SOCKET_DIR), 0770); err != nil {
+		return
+	}
+	socket = filepath.Join(userCacheDir, DEFAULT_SOCKET_DIR, DEFAULT_SOCKET_FILENAME)
+
+	return
+}
+
+// Starts this Shui again in the background, in its own session and detached from the terminal.
+func daemonize(socket string) (err error) {
+	var (
+		devNull    *os.File // Input and output for the background Shui.
+		executable string   // Path to this Shui.
+	)
+
+	if executable, err = os.Executable(); err != nil {
+		return
+	}
+	if devNull, err = os.OpenFile(os.DevNull, os.O_RDWR, 0); err != nil {
+		return
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DAEMON_ENV_VAR+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = devNull, devNull, devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	fmt.Printf("Started shui in the background (pid %d)\n", cmd.Process.Pid)
+	fmt.Printf("Attach with: shui --attach --rpc-socket %s\n", socket)
+
+	return cmd.Process.Release()
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/spacez320/shui"
 	"github.com/spacez320/shui/internal/lib"
+	"github.com/spacez320/shui/pkg/storage"
 	"github.com/spf13/pflag"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -79,19 +80,21 @@ var (
 	// Aliases to apply for configuration settings, mainly to account for differences between flags
 	// (the left column) and configuration files (the right column).
 	configurationAliases = map[string]string{
-		"elasticsearch.addr":     "elasticsearch-addr",
-		"elasticsearch.index":    "elasticsearch-index",
-		"elasticsearch.password": "elasticsearch-password",
-		"elasticsearch.user":     "elasticsearch-user",
-		"tui.padding.bottom":     "outer-padding-bottom",
-		"tui.padding.left":       "outer-padding-left",
-		"tui.padding.right":      "outer-padding-right",
-		"tui.padding.top":        "outer-padding-top",
-		"prometheus.exporter":    "prometheus-exporter",
-		"prometheus.pushgateway": "prometheus-pushgateway",
-		"tui.show.help":          "show-help",
-		"tui.show.logs":          "show-logs",
-		"tui.show.status":        "show-status",
+		"dashboard.columns":       "dashboard-columns",
+		"elasticsearch.addr":      "elasticsearch-addr",
+		"elasticsearch.index":     "elasticsearch-index",
+		"elasticsearch.password":  "elasticsearch-password",
+		"elasticsearch.user":      "elasticsearch-user",
+		"tui.padding.bottom":      "outer-padding-bottom",
+		"tui.padding.left":        "outer-padding-left",
+		"tui.padding.right":       "outer-padding-right",
+		"tui.padding.top":         "outer-padding-top",
+		"prometheus.exporter":     "prometheus-exporter",
+		"prometheus.pushgateway":  "prometheus-pushgateway",
+		"prometheus.remote-write": "prometheus-remote-write",
+		"tui.show.help":           "show-help",
+		"tui.show.logs":           "show-logs",
+		"tui.show.status":         "show-status",
 	}
 
 	logger                 = log.Default() // Logging system.
@@ -103,14 +106,27 @@ var (
 	} // Log levels acceptable as a flag.
 )
 
+// Applies configuration file entries to the flags they stand in for, unless the flag was given.
+func applyConfigurationAliases(flags *flag.FlagSet) {
+	for k, v := range configurationAliases {
+		if f := flags.Lookup(v); viper.IsSet(k) && (f == nil || !f.Changed) {
+			viper.Set(v, viper.Get(k))
+		}
+	}
+}
+
 func main() {
 	var (
-		display       DisplayModeArg // Display mode to use for results.
-		err           error          // General error holder.
-		expressions   []string       // Expressions to apply to query results.
-		mode          QueryModeArg   // Mode to execute under.
-		queries       []string       // Queries to execute.
-		userConfigDir string         // User configuration directory.
+		alerts        []lib.AlertConfig   // Alerts on query results.
+		derived       []lib.DerivedConfig // Series derived from query results.
+		display       DisplayModeArg      // Display mode to use for results.
+		err           error               // General error holder.
+		expressions   []string            // Expressions to apply to query results.
+		mode          QueryModeArg        // Mode to execute under.
+		queries       []string            // Queries to execute.
+		queryConfigs  []lib.QueryConfig   // Query specific configuration.
+		storageSync   storage.SyncPolicy  // Policy for syncing persisted results.
+		userConfigDir string              // User configuration directory.
 	)
 
 	// Retrieve the user config directory.
@@ -120,7 +136,11 @@ func main() {
 	}
 
 	// Define configuration defaults.
+	viper.SetDefault("attach", false)
+	viper.SetDefault("chart-precision", lib.DEFAULT_CHART_PRECISION)
 	viper.SetDefault("count", 1)
+	viper.SetDefault("dashboard-columns", lib.DEFAULT_DASHBOARD_COLUMNS)
+	viper.SetDefault("daemon", false)
 	viper.SetDefault("delay", 3)
 	viper.SetDefault("disable-config", false)
 	viper.SetDefault("display", "raw")
@@ -129,6 +149,8 @@ func main() {
 	viper.SetDefault("elasticsearch-password", "")
 	viper.SetDefault("elasticsearch-user", "")
 	viper.SetDefault("expr", []string{})
+	viper.SetDefault("expr-staleness", lib.DEFAULT_EXPR_STALENESS)
+	viper.SetDefault("expr-window", lib.DEFAULT_EXPR_WINDOW)
 	viper.SetDefault("filters", []string{})
 	viper.SetDefault("history", true)
 	viper.SetDefault("labels", []string{})
@@ -141,15 +163,24 @@ func main() {
 	viper.SetDefault("outer-padding-top", -1)
 	viper.SetDefault("prometheus-exporter", "")
 	viper.SetDefault("prometheus-pushgateway", "")
+	viper.SetDefault("prometheus-remote-write", "")
 	viper.SetDefault("query", []string{})
 	viper.SetDefault("rpc-port", 12345)
+	viper.SetDefault("rpc-socket", "")
 	viper.SetDefault("show-help", true)
 	viper.SetDefault("show-logs", false)
 	viper.SetDefault("show-status", true)
 	viper.SetDefault("silent", false)
+	viper.SetDefault("stop", false)
+	viper.SetDefault("storage-sync", "periodic")
+	viper.SetDefault("timeout", 0)
 	viper.SetDefault("version", false)
 
 	// Define arguments.
+	flag.Bool("attach", viper.GetBool("attach"),
+		"Attach to a Shui running in the background. Implies read mode.")
+	flag.Bool("daemon", viper.GetBool("daemon"),
+		"Run queries in the background, without displaying results. Implies silent.")
 	flag.Bool(
 		"disable-config",
 		viper.GetBool("disable-config"),
@@ -160,14 +191,27 @@ func main() {
 	flag.Bool("show-logs", viper.GetBool("show-logs"), "Whether or not to show log displays.")
 	flag.Bool("show-status", viper.GetBool("show-status"), "Whether or not to show status displays.")
 	flag.Bool("silent", viper.GetBool("silent"), "Don't output anything to a console.")
+	flag.Bool("stop", viper.GetBool("stop"), "Stop a Shui running in the background.")
 	flag.Bool("version", viper.GetBool("version"), "Show version.")
+	flag.Int("chart-precision", viper.GetInt("chart-precision"),
+		"Decimal places of values in chart displays.")
 	flag.Int("count", viper.GetInt("count"), "Number of query executions. -1 for continuous.")
+	flag.Int("dashboard-columns", viper.GetInt("dashboard-columns"),
+		"Number of query panels in each row of dashboard displays.")
 	flag.Int("delay", viper.GetInt("delay"), "Delay between queries (seconds).")
+	flag.Int("expr-staleness", viper.GetInt("expr-staleness"),
+		"Age past which results of other queries are left out of expressions (seconds). 0 for none.")
+	flag.Int("expr-window", viper.GetInt("expr-window"),
+		"Number of recent results available to windowed expression functions.")
 	flag.Int("outer-padding-bottom", viper.GetInt("outer-padding-bottom"), "Bottom display padding.")
 	flag.Int("outer-padding-left", viper.GetInt("outer-padding-left"), "Left display padding.")
 	flag.Int("outer-padding-right", viper.GetInt("outer-padding-right"), "Right display padding.")
 	flag.Int("outer-padding-top", viper.GetInt("outer-padding-top"), "Top display padding.")
-	flag.Int("rpc-port", viper.GetInt("rpc-port"), "Port for RPC.")
+	flag.Int("rpc-port", viper.GetInt("rpc-port"),
+		"Local port to serve results on, or to read results from in read mode. Results are only "+
+			"served when a port or socket is given.")
+	flag.Int("timeout", viper.GetInt("timeout"),
+		"Time before a query execution is cancelled (seconds). 0 for no timeout.")
 	flag.String(
 		"config",
 		filepath.Join(userConfigDir, DEFAULT_CONFIG_FILE_DIR, DEFAULT_CONFIG_FILE_NAME),
@@ -187,6 +231,12 @@ func main() {
 		"Address to present Prometheus metrics.")
 	flag.String("prometheus-pushgateway", viper.GetString("prometheus-pushgateway"),
 		"Address for Prometheus Pushgateway.")
+	flag.String("prometheus-remote-write", viper.GetString("prometheus-remote-write"),
+		"Address of a Prometheus remote write endpoint.")
+	flag.String("rpc-socket", viper.GetString("rpc-socket"),
+		"Unix socket to serve results on, or to read results from in read mode. Preferred over a port.")
+	flag.String("storage-sync", viper.GetString("storage-sync"),
+		fmt.Sprintf("When to sync persisted results to disk (%s).", maps.Values(storage.SyncPolicies)))
 	flag.StringArray("expr", viper.GetStringSlice("expr"),
 		"Expression to apply to output. Can be supplied multiple times.")
 	flag.StringArray("query", viper.GetStringSlice("query"), "Query to execute. Can be supplied "+
@@ -220,19 +270,7 @@ func main() {
 	}
 
 	// Manage configuration aliases.
-	for k, v := range configurationAliases {
-		// FIXME There is currently a bug preventing Viper from doing overrides correctly with aliases.
-		// Therefore, we circumvent the alias behavior by comparing the existence of flags to
-		// configuration file entries, letting the former override the latter and setting them to the
-		// same thing, making the alias registration a little pointless, but safe.
-		//
-		// See: https://github.com/spf13/viper/issues/689
-		if viper.Get(k) != nil {
-			// Equalize the flag and config values.
-			viper.Set(v, k)
-		}
-		viper.RegisterAlias(v, k)
-	}
+	applyConfigurationAliases(flag.CommandLine)
 
 	// Display usage.
 	if viper.GetBool("help") {
@@ -251,6 +289,28 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Shuis in the background are read from a socket, which has a default location.
+	if viper.GetBool("attach") {
+		mode.queryMode = shui.MODE_READ
+	}
+	if (viper.GetBool("attach") || viper.GetBool("daemon") || viper.GetBool("stop")) &&
+		viper.GetString("rpc-socket") == "" {
+		socket, err := defaultSocket()
+		if err != nil {
+			panic(err)
+		}
+		viper.Set("rpc-socket", socket)
+	}
+
+	// Stop a Shui in the background, which is only controlled over its socket.
+	if viper.GetBool("stop") {
+		if err := lib.StopRemote("unix", viper.GetString("rpc-socket")); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to stop the background Shui: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Determine queries to run. In order of preference, queries may come from stdin, flags, or
 	// configuration files, but may not combine from multiple sources.
 	f, err := os.Stdin.Stat()
@@ -270,9 +330,23 @@ func main() {
 		}
 	} else if viper.InConfig("query") {
 		// Queries are provided in the configuration file.
-		for _, query := range viper.Get("query").([]interface{}) {
-			queries = append(queries, query.(map[string]interface{})["command"].(string))
+		err = viper.UnmarshalKey("query", &queryConfigs)
+		if err != nil {
+			panic(err)
+		}
+		for _, queryConfig := range queryConfigs {
+			if err = lib.ValidateParser(queryConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			if err = storage.ValidateMetrics(queryConfig.Metrics); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			queries = append(queries, queryConfig.Command)
 		}
+	} else if mode.queryMode == shui.MODE_READ {
+		// Queries will be discovered from the Shui being read from.
 	} else {
 		// No queries were provided.
 		flag.Usage()
@@ -280,6 +354,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Determine the display mode, which may also come from configuration files.
+	if !pflag.Lookup("display").Changed && viper.InConfig("display") {
+		if err = display.Set(viper.GetString("display")); err != nil {
+			flag.Usage()
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Determine how persisted results are synced.
+	storageSync, err = storage.SyncPolicyFromString(viper.GetString("storage-sync"))
+	if err != nil {
+		flag.Usage()
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
 	// Determine expressions to use. In order of preference, expressions may come from flags or
 	// configuration files, but may not combine from multiple sources. This is mostly to mirror what
 	// queries are doing.
@@ -288,12 +379,46 @@ func main() {
 	} else if viper.InConfig("expressions") {
 		expressions = viper.GetStringSlice("expressions")
 	}
+	if err = lib.CompileExpressions(expressions); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Determine derived series, which may only be defined in configuration files. Series are derived
+	// where queries run, so there are none to derive when reading results.
+	if viper.InConfig("derived") && mode.queryMode != shui.MODE_READ {
+		if err = viper.UnmarshalKey("derived", &derived); err != nil {
+			panic(err)
+		}
+	}
+	if err = lib.ValidateDerived(derived, queries); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Determine alerts, which may also only be defined in configuration files and are evaluated
+	// where queries run.
+	if viper.InConfig("alert") && mode.queryMode != shui.MODE_READ {
+		if err = viper.UnmarshalKey("alert", &alerts); err != nil {
+			panic(err)
+		}
+	}
+	if err = lib.ValidateAlerts(alerts, derived, queries); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Start again in the background, leaving this Shui to exit.
+	if viper.GetBool("daemon") && !isDaemon() {
+		if err = daemonize(viper.GetString("rpc-socket")); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to start in the background: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	// Set-up logging.
-	if viper.GetBool("silent") {
-		// Silence all output.
-		logger.SetOutput(io.Discard)
-	} else if viper.GetString("log-file") != "" {
+	if viper.GetString("log-file") != "" {
 		// Write logs to a file.
 		logF, err := os.OpenFile(viper.GetString("log-file"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 		if err != nil {
@@ -305,6 +430,9 @@ func main() {
 				logF,
 				&slog.HandlerOptions{Level: logLevelStrToSlogLevel[viper.GetString("log-level")]},
 			)))
+	} else if viper.GetBool("silent") || viper.GetBool("daemon") {
+		// Silence all output.
+		logger.SetOutput(io.Discard)
 	} else {
 		// Set the default to be standard output--result modes may change this.
 		slog.SetDefault(slog.New(slog.NewTextHandler(
@@ -313,31 +441,52 @@ func main() {
 		)))
 	}
 
+	// Results are only served when asked for, with a port or socket.
+	serve := viper.GetString("rpc-socket") != "" ||
+		flag.Lookup("rpc-port").Changed ||
+		viper.InConfig("rpc-port")
+
 	// Build general configuration.
 	config := lib.Config{
-		Count:                  viper.GetInt("count"),
-		Delay:                  viper.GetInt("delay"),
-		DisplayMode:            int(display.displayMode),
-		ElasticsearchAddr:      viper.GetString("elasticsearch-addr"),
-		ElasticsearchIndex:     viper.GetString("elasticsearch-index"),
-		ElasticsearchPassword:  viper.GetString("elasticsearch-password"),
-		ElasticsearchUser:      viper.GetString("elasticsearch-user"),
-		Expressions:            expressions,
-		Filters:                viper.GetStringSlice("filters"),
-		History:                viper.GetBool("history"),
-		Labels:                 viper.GetStringSlice("labels"),
-		LogLevel:               viper.GetString("log-level"),
-		LogMulti:               viper.GetString("log-file") != "",
-		Mode:                   int(mode.queryMode),
-		Port:                   viper.GetInt("port"),
-		PrometheusExporterAddr: viper.GetString("prometheus-exporter"),
-		PushgatewayAddr:        viper.GetString("prometheus-pushgateway"),
-		Queries:                queries,
-		ReadStdin:              readStdin,
+		Alerts:                    alerts,
+		Count:                     viper.GetInt("count"),
+		Delay:                     viper.GetInt("delay"),
+		Derived:                   derived,
+		DisplayMode:               int(display.displayMode),
+		ElasticsearchAddr:         viper.GetString("elasticsearch-addr"),
+		ElasticsearchIndex:        viper.GetString("elasticsearch-index"),
+		ElasticsearchPassword:     viper.GetString("elasticsearch-password"),
+		ElasticsearchUser:         viper.GetString("elasticsearch-user"),
+		ExprStaleness:             viper.GetInt("expr-staleness"),
+		ExprWindow:                viper.GetInt("expr-window"),
+		Expressions:               expressions,
+		Filters:                   viper.GetStringSlice("filters"),
+		History:                   viper.GetBool("history"),
+		Labels:                    viper.GetStringSlice("labels"),
+		LogLevel:                  viper.GetString("log-level"),
+		LogMulti:                  viper.GetString("log-file") != "",
+		Mode:                      int(mode.queryMode),
+		Port:                      viper.GetInt("rpc-port"),
+		PrometheusExporterAddr:    viper.GetString("prometheus-exporter"),
+		PrometheusRemoteWriteAddr: viper.GetString("prometheus-remote-write"),
+		PushgatewayAddr:           viper.GetString("prometheus-pushgateway"),
+		Queries:                   queries,
+		QueryConfigs:              make(map[string]lib.QueryConfig, len(queryConfigs)),
+		RPCSocket:                 viper.GetString("rpc-socket"),
+		ReadStdin:                 readStdin,
+		Serve:                     serve,
+		Silent:                    viper.GetBool("silent") || viper.GetBool("daemon"),
+		StorageSync:               int(storageSync),
+		Timeout:                   viper.GetInt("timeout"),
+	}
+	for _, queryConfig := range queryConfigs {
+		config.QueryConfigs[queryConfig.Command] = queryConfig
 	}
 
 	// Build display configuration.
 	displayConfig := lib.NewDisplayConfig()
+	displayConfig.ChartPrecision = viper.GetInt("chart-precision")
+	displayConfig.DashboardColumns = viper.GetInt("dashboard-columns")
 	displayConfig.ShowHelp = viper.GetBool("show-help")
 	displayConfig.ShowLogs = viper.GetBool("show-logs")
 	displayConfig.ShowStatus = viper.GetBool("show-status")
@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 const (
@@ -75,3 +78,39 @@ func TestCLI(t *testing.T) {
 		})
 	}
 }
+
+// Run configuration alias tests.
+func TestConfigurationAliases(t *testing.T) {
+	defer viper.Reset()
+
+	flags := flag.NewFlagSet("shui", flag.ContinueOnError)
+	flags.Int("dashboard-columns", 0, "")
+	flags.String("elasticsearch-index", "", "")
+	flags.Parse([]string{"--elasticsearch-index", "flag"})
+	viper.BindPFlags(flags)
+	viper.SetConfigType("toml")
+	viper.ReadConfig(strings.NewReader(`
+[dashboard]
+columns = 3
+
+[elasticsearch]
+index = "config"
+
+[tui.padding]
+top = 2
+`))
+	applyConfigurationAliases(flags)
+
+	// It applies configuration file entries, even without a flag to stand in for.
+	if got := viper.GetInt("dashboard-columns"); got != 3 {
+		t.Errorf("Got: %v Expected: %v\n", got, 3)
+	}
+	if got := viper.GetInt("outer-padding-top"); got != 2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2)
+	}
+
+	// It lets flags override configuration file entries.
+	if got := viper.GetString("elasticsearch-index"); got != "flag" {
+		t.Errorf("Got: %v Expected: %v\n", got, "flag")
+	}
+}
@@ -8,12 +8,16 @@ require (
 	github.com/elastic/go-elasticsearch/v8 v8.13.1
 	github.com/expr-lang/expr v1.16.7
 	github.com/gdamore/tcell/v2 v2.7.4
+	github.com/golang/snappy v1.0.0
 	github.com/mum4k/termdash v0.20.0
 	github.com/prometheus/client_golang v1.19.0
 	github.com/prometheus/procfs v0.12.0
 	github.com/rivo/tview v0.0.0-20231206124440-5f078138442e
 	github.com/samber/slog-multi v1.0.2
+	github.com/spf13/pflag v1.0.5
+	github.com/spf13/viper v1.19.0
 	golang.org/x/exp v0.0.0-20231226003508-02704c960a9b
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
@@ -39,8 +43,6 @@ require (
 	github.com/sourcegraph/conc v0.3.0 // indirect
 	github.com/spf13/afero v1.11.0 // indirect
 	github.com/spf13/cast v1.6.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/spf13/viper v1.19.0 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
 	go.opentelemetry.io/otel v1.24.0 // indirect
 	go.opentelemetry.io/otel/metric v1.24.0 // indirect
@@ -50,7 +52,6 @@ require (
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/term v0.17.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
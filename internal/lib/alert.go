@@ -0,0 +1,347 @@
+//
+// Alerts on the results of queries.
+//
+// Alerts evaluate a condition on each result of a query as it arrives, moving between being ok,
+// pending (the condition holds, but not yet for long enough) and firing. Alerts run a command or
+// post to a webhook when they fire or resolve, and their states are stored as a series of their
+// own, so that they survive restarts along with history.
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+// Represents the state of an alert.
+type alertState int
+
+// Fetches a common name from an alert state value.
+func (s alertState) String() string {
+	return alertStates[s]
+}
+
+// Alert state constants.
+const (
+	ALERT_STATE_OK      alertState = iota // The condition doesn't hold. Serves as the 'default.'
+	ALERT_STATE_PENDING                   // The condition holds, but not for long enough to fire.
+	ALERT_STATE_FIRING                    // The condition held for long enough, and hasn't cleared.
+)
+
+// Misc. constants.
+const (
+	ALERT_ACTION_TIMEOUT = 10      // Seconds before alert commands and webhooks are cancelled.
+	ALERT_STATE_LABEL    = "state" // Label of the values of alert series.
+)
+
+var (
+	alerts      map[string]*alert // Alerts being evaluated, by name.
+	alertsMutex = &sync.RWMutex{} // Mutex for managing alerts.
+
+	alertStates = map[alertState]string{
+		ALERT_STATE_OK:      "ok",
+		ALERT_STATE_PENDING: "pending",
+		ALERT_STATE_FIRING:  "firing",
+	} // Mapping of alert states to their names.
+)
+
+// An alert and its current state.
+type alert struct {
+	config AlertConfig // How the alert is evaluated and acted on.
+	since  time.Time   // Time the alert entered its state.
+	state  alertState  // Current state.
+}
+
+// Fetches an alert state from its name.
+func alertStateFromString(s string) (alertState, error) {
+	for k, v := range alertStates {
+		if v == s {
+			return k, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Invalid alert state: %s", s))
+}
+
+// Moves an alert to its next state, given whether its condition and its clear condition hold at a
+// point in time. Firing alerts only resolve once cleared, while others are ok as soon as their
+// condition no longer holds. Returns true if the state changed.
+func (a *alert) transition(condition, cleared bool, at time.Time) bool {
+	var (
+		next alertState // State to move to.
+	)
+
+	switch {
+	case (*a).state == ALERT_STATE_FIRING && !cleared:
+		next = ALERT_STATE_FIRING
+	case (*a).state == ALERT_STATE_FIRING || !condition:
+		next = ALERT_STATE_OK
+	case (*a).state == ALERT_STATE_PENDING && at.Sub((*a).since) >= (*a).config.For,
+		(*a).state == ALERT_STATE_OK && (*a).config.For <= 0:
+		next = ALERT_STATE_FIRING
+	default:
+		next = ALERT_STATE_PENDING
+	}
+	if next == (*a).state {
+		return false
+	}
+	(*a).since, (*a).state = at, next
+
+	return true
+}
+
+// Checks that alerts may be evaluated on queries or derived series.
+func ValidateAlerts(alerts []AlertConfig, derived []DerivedConfig, queries []string) (err error) {
+	var (
+		names  []string                // Names of alerts validated so far.
+		series = slices.Clone(queries) // Queries and derived series that alerts may watch.
+	)
+
+	for _, derivedSeries := range derived {
+		series = append(series, derivedSeries.Name)
+	}
+
+	for _, alert := range alerts {
+		switch {
+		case alert.Name == "":
+			return errors.New(fmt.Sprintf("Alert on %s has no name", alert.Query))
+		case slices.Contains(series, alert.Name) || slices.Contains(names, alert.Name):
+			return errors.New(fmt.Sprintf("Alert %s is already a query, series or alert", alert.Name))
+		case !slices.Contains(series, alert.Query):
+			return errors.New(fmt.Sprintf("Alert %s has an unknown query %s", alert.Name, alert.Query))
+		case alert.Condition == "":
+			return errors.New(fmt.Sprintf("Alert %s has no condition", alert.Name))
+		case alert.For < 0:
+			return errors.New(fmt.Sprintf("Alert %s has a negative duration", alert.Name))
+		}
+		if alert.Webhook != "" {
+			if _, err = url.ParseRequestURI(alert.Webhook); err != nil {
+				return errors.New(fmt.Sprintf("Alert %s has an invalid webhook: %v", alert.Name, err))
+			}
+		}
+		if err = CompileExpressions([]string{alert.Condition}); err != nil {
+			return
+		}
+		if alert.Clear != "" {
+			if err = CompileExpressions([]string{alert.Clear}); err != nil {
+				return
+			}
+		}
+		names = append(names, alert.Name)
+	}
+
+	return
+}
+
+// Sets-up alerts, restoring their states from stored results so that alerts carry over restarts.
+func initAlerts() {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	alerts = make(map[string]*alert, len(config.Alerts))
+	for _, alertConfig := range config.Alerts {
+		a := &alert{config: alertConfig}
+		if latest := store.GetLatest(alertConfig.Name, 1); len(latest) > 0 {
+			state, err := alertStateFromString(latest[0].Value)
+			if err != nil {
+				e(err)
+				continue
+			}
+			(*a).since, (*a).state = latest[0].Time, state
+		}
+		alerts[alertConfig.Name] = a
+	}
+}
+
+// Evaluates a condition of an alert on a result. Conditions must produce a boolean.
+func alertCondition(
+	query, condition string,
+	result, prevResult storage.Result,
+	stored map[string]interface{},
+) (holds bool, err error) {
+	output, err := exprRun(query, condition, result, prevResult, stored)
+	if err != nil {
+		return
+	}
+	holds, ok := output.(bool)
+	if !ok {
+		return false, errors.New(
+			fmt.Sprintf("Alert condition must produce a boolean: %s (output %v)", condition, output))
+	}
+
+	return
+}
+
+// Evaluates alerts on a result of a query, storing the states of alerts that change and acting on
+// those that fire or resolve. Results of queries that didn't complete, or that conditions fail on,
+// leave alerts as they are.
+func evaluateAlerts(query string, result storage.Result, history bool) {
+	var (
+		prevResult storage.Result         // Result before this one.
+		stored     map[string]interface{} // Environment drawn from stored results.
+	)
+
+	if result.Status != storage.RESULT_STATUS_OK || result.IsEmptyValues() {
+		return
+	}
+
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	for _, a := range alerts {
+		if (*a).config.Query != query {
+			continue
+		}
+		if stored == nil {
+			if before := store.GetBefore(query, result.Time); len(before) > 0 {
+				prevResult = before[len(before)-1]
+			}
+			stored = exprStored(query, result)
+		}
+
+		condition, err := alertCondition(
+			query, (*a).config.Condition, result, prevResult, stored)
+		if err != nil {
+			e(err)
+			continue
+		}
+		cleared := !condition
+		if (*a).state == ALERT_STATE_FIRING && (*a).config.Clear != "" {
+			if cleared, err = alertCondition(
+				query, (*a).config.Clear, result, prevResult, stored); err != nil {
+				e(err)
+				continue
+			}
+		}
+
+		prevState := (*a).state
+		if !(*a).transition(condition, cleared, result.Time) {
+			continue
+		}
+		slog.Info("Alert changed", "alert", (*a).config.Name, "from", prevState, "to", (*a).state)
+
+		// States are stored as numbers, so that integrations can make use of them.
+		_, err = store.PutResult((*a).config.Name, history, storage.Result{
+			Time:   result.Time,
+			Value:  (*a).state.String(),
+			Values: storage.Values{int64((*a).state)},
+		})
+		e(err)
+
+		if (*a).state == ALERT_STATE_FIRING || prevState == ALERT_STATE_FIRING {
+			go notifyAlert(*a, prevState, result.Map(store.GetResultLabels(query, result)))
+		}
+	}
+}
+
+// Runs the actions of an alert that fired or resolved. Commands are given details of the alert as
+// environment variables, while webhooks are posted them as JSON.
+func notifyAlert(a alert, prevState alertState, result map[string]interface{}) {
+	var (
+		err error // General error holder.
+	)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), time.Duration(ALERT_ACTION_TIMEOUT)*time.Second)
+	defer cancel()
+
+	if a.config.Command != "" {
+		cmd := exec.CommandContext(ctx, "bash", "-c", a.config.Command)
+		cmd.Env = append(
+			os.Environ(),
+			"SHUI_ALERT_NAME="+a.config.Name,
+			"SHUI_ALERT_QUERY="+a.config.Query,
+			"SHUI_ALERT_STATE="+a.state.String(),
+			"SHUI_ALERT_PREVIOUS_STATE="+prevState.String(),
+			"SHUI_ALERT_TIME="+a.since.Format(time.RFC3339),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			slog.Error(
+				"Alert command failed", "alert", a.config.Name, "err", err, "output", string(output))
+		}
+	}
+
+	if a.config.Webhook != "" {
+		if err = postAlert(ctx, a, prevState, result); err != nil {
+			slog.Error("Alert webhook failed", "alert", a.config.Name, "err", err)
+		}
+	}
+}
+
+// Posts an alert that fired or resolved to its webhook.
+func postAlert(
+	ctx context.Context,
+	a alert,
+	prevState alertState,
+	result map[string]interface{},
+) (err error) {
+	var (
+		payload  []byte         // Body of the request.
+		request  *http.Request  // Request to the webhook.
+		response *http.Response // Response from the webhook.
+	)
+
+	payload, err = json.Marshal(map[string]interface{}{
+		"name":          a.config.Name,
+		"previousState": prevState.String(),
+		"query":         a.config.Query,
+		"result":        result,
+		"state":         a.state.String(),
+		"time":          a.since,
+	})
+	if err != nil {
+		return
+	}
+
+	request, err = http.NewRequestWithContext(
+		ctx, http.MethodPost, a.config.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if response, err = http.DefaultClient.Do(request); err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("Unexpected webhook response: %s", response.Status))
+	}
+
+	return
+}
+
+// Describes alerts that are firing, for status displays. Empty if none are.
+func alertStatusText() string {
+	var (
+		firing []string // Names of firing alerts.
+	)
+
+	alertsMutex.RLock()
+	for name, a := range alerts {
+		if (*a).state == ALERT_STATE_FIRING {
+			firing = append(firing, name)
+		}
+	}
+	alertsMutex.RUnlock()
+	if len(firing) == 0 {
+		return ""
+	}
+	sort.Strings(firing)
+
+	return "firing: " + strings.Join(firing, ", ")
+}
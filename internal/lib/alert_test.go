@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestAlertTransition(t *testing.T) {
+	start := time.Now()
+	a := alert{config: AlertConfig{For: time.Minute}}
+
+	// It waits for conditions to hold long enough before firing.
+	for _, step := range []struct {
+		condition, cleared bool
+		at                 time.Duration
+		expected           alertState
+	}{
+		{true, false, 0, ALERT_STATE_PENDING},
+		{true, false, 30 * time.Second, ALERT_STATE_PENDING},
+		{false, true, 40 * time.Second, ALERT_STATE_OK},
+		{true, false, 50 * time.Second, ALERT_STATE_PENDING},
+		{true, false, 110 * time.Second, ALERT_STATE_FIRING},
+		// It keeps firing until cleared, even once the condition no longer holds.
+		{false, false, 120 * time.Second, ALERT_STATE_FIRING},
+		{false, true, 130 * time.Second, ALERT_STATE_OK},
+	} {
+		a.transition(step.condition, step.cleared, start.Add(step.at))
+		if a.state != step.expected {
+			t.Errorf("Got: %v Expected: %v\n", a.state, step.expected)
+		}
+	}
+
+	// It fires immediately without a duration.
+	a = alert{}
+	if !a.transition(true, false, start) || a.state != ALERT_STATE_FIRING {
+		t.Errorf("Got: %v Expected: %v\n", a.state, ALERT_STATE_FIRING)
+	}
+}
+
+func TestValidateAlerts(t *testing.T) {
+	derived := []DerivedConfig{{Expressions: []string{"1"}, Name: "bar", Query: "foo"}}
+
+	// It accepts alerts on known queries and derived series.
+	alerts := []AlertConfig{
+		{Condition: "true", Name: "fizz", Query: "foo"},
+		{Clear: "false", Condition: "true", Name: "buzz", Query: "bar"},
+	}
+	if err := ValidateAlerts(alerts, derived, []string{"foo"}); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+
+	// It rejects unusable alerts.
+	for _, alerts := range [][]AlertConfig{
+		{{Condition: "true", Query: "foo"}},
+		{{Condition: "true", Name: "bar", Query: "foo"}},
+		{{Condition: "true", Name: "fizz", Query: "buzz"}},
+		{{Name: "fizz", Query: "foo"}},
+		{{Condition: "true", For: -time.Second, Name: "fizz", Query: "foo"}},
+		{{Condition: "true", Name: "fizz", Query: "foo", Webhook: "example"}},
+		{{Clear: "1 +", Condition: "true", Name: "fizz", Query: "foo"}},
+	} {
+		if err := ValidateAlerts(alerts, derived, []string{"foo"}); err == nil {
+			t.Errorf("Got: %v Expected: an error\n", err)
+		}
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	var err error
+
+	notifications := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		notifications <- payload
+	}))
+	defer server.Close()
+
+	config = Config{Alerts: []AlertConfig{{
+		Clear:     `get(result, "0") < 5`,
+		Condition: `get(result, "0") > 10`,
+		Name:      "high",
+		Query:     "foo",
+		Webhook:   server.URL,
+	}}}
+	defer func() { config = Config{} }()
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initStorage([]string{"foo"}, []string{}, false)
+	defer closeServer()
+
+	// It fires, storing the state and notifying the webhook.
+	start := time.Now()
+	AddResult("foo", storage.Result{Time: start, Value: "20"}, false)
+	if got := alertStatusText(); got != "firing: high" {
+		t.Errorf("Got: %v Expected: %v\n", got, "firing: high")
+	}
+	select {
+	case payload := <-notifications:
+		if payload["state"] != "firing" || payload["previousState"] != "ok" {
+			t.Errorf("Got: %v Expected: %v\n", payload, "a firing notification")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Got: %v Expected: %v\n", nil, "a firing notification")
+	}
+
+	// It keeps firing between the condition and the clear condition.
+	AddResult("foo", storage.Result{Time: start.Add(time.Second), Value: "7"}, false)
+	AddResult("foo", storage.Result{Time: start.Add(2 * time.Second), Value: "3"}, false)
+	results := store.GetAll("high")
+	if len(results) != 2 || results[0].Value != "firing" || results[1].Value != "ok" {
+		t.Errorf("Got: %v Expected: %v\n", results, "firing, then ok")
+	}
+	if got := alertStatusText(); got != "" {
+		t.Errorf("Got: %v Expected: %v\n", got, "")
+	}
+
+	// It restores states from stored results.
+	AddResult("foo", storage.Result{Time: start.Add(3 * time.Second), Value: "30"}, false)
+	initAlerts()
+	if got := alerts["high"].state; got != ALERT_STATE_FIRING {
+		t.Errorf("Got: %v Expected: %v\n", got, ALERT_STATE_FIRING)
+	}
+}
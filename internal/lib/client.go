@@ -1,32 +1,80 @@
 //
 // Client for RPC.
-//
-// TODO RPC is currently disabled.
 
 package lib
 
 import (
-	// "fmt"
 	"net/rpc"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
 )
 
 var (
-	client *rpc.Client
+	client *rpc.Client // Client for a remote Shui, when in 'read' mode.
 )
 
 // Establish the RPC client to query results.
-func initClient(port string) {
-	// var (
-	// 	err   error
-	// 	reply storage.ResultsRPC
-	// )
-	//
-	// client, err = rpc.DialHTTP("tcp", fmt.Sprintf("localhost:%v", port))
-	// e(err)
-	//
-	// err = client.Call("Results.GetAllRPC", storage.ArgsRPC{}, &reply)
-	// e(err)
-	//
-	// // TODO For now, just print results until we define actions that may be done upon them.
-	// fmt.Printf("Got: %v\n", reply.Results)
+func initClient(network, addr string) (err error) {
+	client, err = rpc.DialHTTP(network, addr)
+
+	return
+}
+
+// Copies remote results and labels into local storage.
+func mirrorResults(query string, reply *storage.ResultsRPC) (err error) {
+	var (
+		schemas = (*reply).LabelSchemas // Remote label schemas.
+	)
+
+	// Labels are copied first, so that results are labelled as they were remotely.
+	for _, schema := range schemas[min(len(store.GetLabelSchemas(query)), len(schemas)):] {
+		if err = store.PutLabelSchema(query, schema); err != nil {
+			return
+		}
+	}
+
+	for _, result := range (*reply).Results {
+		if _, err = store.PutResult(query, false, result); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Copies all existing remote results for queries into local storage.
+func mirrorAllResults(queries []string) (err error) {
+	var (
+		reply storage.ResultsRPC // Remote results.
+	)
+
+	for _, query := range queries {
+		reply = storage.ResultsRPC{}
+		err = client.Call(
+			"Storage.GetRange",
+			storage.ArgsRPC{Query: query, EndTime: time.Now()},
+			&reply,
+		)
+		if err != nil {
+			return
+		}
+		if err = mirrorResults(query, &reply); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Lists queries with results in a remote Shui.
+func remoteQueries() (queries []string, err error) {
+	var (
+		reply storage.ResultsRPC // Remote queries.
+	)
+
+	err = client.Call("Storage.Queries", storage.ArgsRPC{}, &reply)
+	queries = reply.Queries
+
+	return
 }
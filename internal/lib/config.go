@@ -3,7 +3,13 @@
 
 package lib
 
-import "log/slog"
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
 
 var (
 	logLevelStrtoSlogLevel = map[string]slog.Level{
@@ -14,18 +20,71 @@ var (
 	} // Mapping of human-readable log levels to Slog levels.
 )
 
+// Per-query configuration. See `[[query]]` configuration file entries for further details.
+type QueryConfig struct {
+	Command   string                  // Query to execute.
+	Delimiter string                  // Separates fields of output for the delimited parser.
+	Display   string                  // Display mode of the query's panel in dashboards.
+	Header    *bool                   // Whether tabular output starts with a header, detected if unset.
+	Key       string                  // Label of the column keying rows, each key becoming a series.
+	Metrics   []storage.MetricConfig  // Prometheus metrics of the query's values.
+	Name      string                  // Name to refer to the query by in expressions.
+	Parser    string                  // Parser for the query's output.
+	Paths     []string                // Paths to values in JSON output.
+	Pattern   string                  // Regular expression with named groups for the regex parser.
+	Retention storage.RetentionPolicy // Limits on the results kept for the query.
+	Timeout   int                     // Seconds before an execution is cancelled, overriding any global timeout.
+}
+
+// Configuration of an alert on the results of a query, or of a derived series. See `[[alert]]`
+// configuration file entries for further details.
+type AlertConfig struct {
+	Clear     string        // Condition resolving a firing alert, otherwise the condition not holding.
+	Command   string        // Command to run when the alert fires or resolves.
+	Condition string        // Expression the alert fires on, producing a boolean.
+	For       time.Duration // Time the condition must hold before the alert fires.
+	Name      string        // Name of the alert, also naming the series of its states.
+	Query     string        // Query the alert watches results of.
+	Webhook   string        // URL to post to when the alert fires or resolves.
+}
+
+// Configuration of a series derived from the results of a query with expressions. Derived series
+// are stored as queries of their own. See `[[derived]]` configuration file entries for further
+// details.
+type DerivedConfig struct {
+	Expressions []string // Expressions producing the series from results of the query.
+	Name        string   // Name of the series, used in place of a query.
+	Query       string   // Query the series is derived from.
+}
+
 // Shareable configuration. See CLI flags for further details.
 type Config struct {
-	Count, Delay, DisplayMode, Mode, Port                                           int
+	Count, Delay, DisplayMode, ExprStaleness, ExprWindow, Mode, Port, StorageSync   int
+	Timeout                                                                         int
 	ElasticsearchAddr, ElasticsearchIndex, ElasticsearchPassword, ElasticsearchUser string
 	Expressions, Filters, Labels, Queries                                           []string
-	History, LogMulti, ReadStdin, Silent                                            bool
+	History, LogMulti, ReadStdin, Serve, Silent                                     bool
 	LogLevel                                                                        string
 	PrometheusExporterAddr                                                          string
+	PrometheusRemoteWriteAddr                                                       string
 	PushgatewayAddr                                                                 string
+	RPCSocket                                                                       string
+	QueryConfigs                                                                    map[string]QueryConfig
+	Alerts                                                                          []AlertConfig
+	Derived                                                                         []DerivedConfig
 }
 
 // Retrieves an Slog level from a human-readable level string.
 func (c *Config) SlogLogLevel() slog.Level {
 	return logLevelStrtoSlogLevel[(*c).LogLevel]
 }
+
+// Determines where results are served from, or read from in 'read' mode. A socket is preferred over
+// a port, which is only ever served locally.
+func (c *Config) RPCAddr() (network, addr string) {
+	if (*c).RPCSocket != "" {
+		return "unix", (*c).RPCSocket
+	}
+
+	return "tcp", fmt.Sprintf("localhost:%d", (*c).Port)
+}
@@ -0,0 +1,84 @@
+//
+// Control of a Shui running in the background.
+//
+// Shuis running in the background (see `Headless`) accept control calls over the same RPC server
+// that serves their results, so that attached Shuis may find out about them or stop them. Control
+// is only accepted on Unix sockets, which only the user running Shui may use.
+
+package lib
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Arguments for control calls.
+type ControlArgs struct{}
+
+// Reply for control calls.
+type ControlReply struct {
+	Pid     int       // Process running the queries.
+	Queries []string  // Queries being run.
+	Started time.Time // Time the Shui started.
+}
+
+// Controls a Shui running in the background.
+type Control struct {
+	started  time.Time // Time control was established.
+	stopChan chan bool // Signals a request to stop.
+}
+
+// Describes the Shui.
+func (c *Control) Status(args *ControlArgs, reply *ControlReply) error {
+	(*reply).Pid = os.Getpid()
+	(*reply).Queries = store.GetQueries()
+	(*reply).Started = (*c).started
+
+	return nil
+}
+
+// Stops the Shui.
+func (c *Control) Stop(args *ControlArgs, reply *ControlReply) error {
+	slog.Info("Received a request to stop")
+
+	select {
+	case (*c).stopChan <- true:
+	default:
+		// A stop is already underway.
+	}
+
+	return nil
+}
+
+// Accepts control calls, signalling stops on the provided channel. Requires the RPC server to be
+// on a Unix socket.
+func initControl(stopChan chan bool) error {
+	if server == nil || listener == nil {
+		return errors.New("Results aren't being served")
+	}
+	if listener.Addr().Network() != "unix" {
+		return errors.New("Control requires a Unix socket")
+	}
+
+	return server.RegisterName("Control", &Control{started: time.Now(), stopChan: stopChan})
+}
+
+// Asks the Shui being read from to stop, if it is running in the background.
+func stopRemote() {
+	if err := client.Call("Control.Stop", ControlArgs{}, &ControlReply{}); err != nil {
+		// Shuis not running in the background can't be stopped remotely.
+		slog.Error("Unable to stop remote Shui", "err", err)
+	}
+}
+
+// Stops a Shui running in the background, serving on an address.
+func StopRemote(network, addr string) (err error) {
+	if err = initClient(network, addr); err != nil {
+		return
+	}
+	defer client.Close()
+
+	return client.Call("Control.Stop", ControlArgs{}, &ControlReply{})
+}
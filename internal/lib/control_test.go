@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestControl(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("foo", "1", false, int64(1))
+
+	socket := filepath.Join(t.TempDir(), "shui.sock")
+	if err = initServer("unix", socket); err != nil {
+		t.Fatal(err)
+	}
+	defer closeServer()
+
+	// It serves on a socket only the user may use.
+	if stat, err := os.Stat(socket); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("Got: %v Expected: %v\n", stat, "a socket with 0600 permissions")
+	}
+
+	stopChan := make(chan bool, 1)
+	if err = initControl(stopChan); err != nil {
+		t.Fatal(err)
+	}
+
+	controlClient, err := rpc.DialHTTP("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer controlClient.Close()
+
+	// It describes the running Shui.
+	reply := ControlReply{}
+	controlClient.Call("Control.Status", ControlArgs{}, &reply)
+	if reply.Pid != os.Getpid() || len(reply.Queries) != 1 || reply.Queries[0] != "foo" {
+		t.Errorf("Got: %v\n", reply)
+	}
+
+	// It signals requests to stop.
+	controlClient.Call("Control.Stop", ControlArgs{}, &reply)
+	select {
+	case <-stopChan:
+	default:
+		t.Error("Stop was not signalled")
+	}
+
+	// It refuses to serve on a socket that is in use.
+	if err = initServer("unix", socket); err == nil {
+		t.Error("Served on a socket in use")
+	}
+}
+
+func TestControlTCP(t *testing.T) {
+	if err := initServer("tcp", "localhost:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer closeServer()
+
+	// It refuses control over TCP, which any local user may reach.
+	if err := initControl(make(chan bool, 1)); err == nil {
+		t.Error("Accepted control over TCP")
+	}
+}
@@ -0,0 +1,85 @@
+//
+// Series derived from the results of queries.
+//
+// Derived series apply expressions to each result of a query as it arrives, storing their output
+// as results of their own. Unlike expressions applied by displays, derived results are persisted,
+// exported and may be displayed like any query.
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+// Checks that derived series may be produced from queries.
+func ValidateDerived(derived []DerivedConfig, queries []string) (err error) {
+	var (
+		names []string // Names of series validated so far.
+	)
+
+	for _, series := range derived {
+		switch {
+		case series.Name == "":
+			return errors.New(fmt.Sprintf("Derived series of %s has no name", series.Query))
+		case slices.Contains(queries, series.Name) || slices.Contains(names, series.Name):
+			return errors.New(
+				fmt.Sprintf("Derived series %s is already a query or series", series.Name))
+		case !slices.Contains(queries, series.Query):
+			return errors.New(
+				fmt.Sprintf("Derived series %s has an unknown query %s", series.Name, series.Query))
+		case len(series.Expressions) == 0:
+			return errors.New(fmt.Sprintf("Derived series %s has no expressions", series.Name))
+		}
+		if err = CompileExpressions(series.Expressions); err != nil {
+			return
+		}
+		names = append(names, series.Name)
+	}
+
+	return
+}
+
+// Stores results of the series derived from a result of a query. Results of queries that didn't
+// complete, or that expressions fail on, derive nothing.
+func deriveResults(query string, result storage.Result, history bool) {
+	var (
+		err error // General error holder.
+	)
+
+	if result.Status != storage.RESULT_STATUS_OK || result.IsEmptyValues() {
+		return
+	}
+
+	for _, series := range config.Derived {
+		if series.Query != query {
+			continue
+		}
+
+		// Expressions refer to the previous result of the series, as they do in displays.
+		var prevResult storage.Result // Previous result of the series.
+		if latest := store.GetLatest(series.Name, 1); len(latest) > 0 {
+			prevResult = latest[0]
+		}
+
+		derivedResult, stored := result, exprStored(query, result)
+		for _, expression := range series.Expressions {
+			if derivedResult, err = exprResult(
+				query, expression, derivedResult, prevResult, stored); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			e(err)
+			continue
+		}
+
+		derivedResult, err = store.PutResult(series.Name, history, derivedResult)
+		e(err)
+
+		evaluateAlerts(series.Name, derivedResult, history)
+	}
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestValidateDerived(t *testing.T) {
+	// It accepts series derived from known queries.
+	derived := []DerivedConfig{{Expressions: []string{"1"}, Name: "bar", Query: "foo"}}
+	if err := ValidateDerived(derived, []string{"foo"}); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+
+	// It rejects unusable series.
+	for _, derived := range [][]DerivedConfig{
+		{{Expressions: []string{"1"}, Query: "foo"}},
+		{{Expressions: []string{"1"}, Name: "foo", Query: "foo"}},
+		{{Expressions: []string{"1"}, Name: "bar", Query: "fizz"}},
+		{{Name: "bar", Query: "foo"}},
+		{{Expressions: []string{"1 +"}, Name: "bar", Query: "foo"}},
+	} {
+		if err := ValidateDerived(derived, []string{"foo"}); err == nil {
+			t.Errorf("Got: %v Expected: an error\n", err)
+		}
+	}
+}
+
+func TestDeriveResults(t *testing.T) {
+	var err error
+
+	config = Config{Derived: []DerivedConfig{
+		{Expressions: []string{`get(result, "0") * 2`}, Name: "double", Query: "foo"},
+		{
+			Expressions: []string{`{"total": get(result, "0") + get(result, "1")}`},
+			Name:        "sum",
+			Query:       "foo",
+		},
+	}}
+	defer func() { config = Config{} }()
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initStorage([]string{"foo"}, []string{}, false)
+	defer closeServer()
+
+	// It stores the output of expressions as results of each derived series.
+	AddResult("foo", storage.Result{Value: "2 3"}, false)
+	results := store.GetAll("double")
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Values, storage.Values{int64(4)}) {
+		t.Errorf("Got: %v Expected: %v\n", results, storage.Values{int64(4)})
+	}
+	labels := store.GetResultLabels("double", results[0])
+	if !reflect.DeepEqual(labels, []string{"double"}) {
+		t.Errorf("Got: %v Expected: %v\n", labels, []string{"double"})
+	}
+
+	// It labels values of derived series from expressions.
+	results = store.GetAll("sum")
+	if got := store.GetResultLabels("sum", results[0]); !reflect.DeepEqual(got, []string{"total"}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"total"})
+	}
+
+	// It derives nothing from results that didn't complete.
+	AddResult("foo", storage.Result{Status: storage.RESULT_STATUS_TIMEOUT}, false)
+	if got := len(store.GetAll("double")); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+}
@@ -0,0 +1,181 @@
+//
+// Differences between text results.
+//
+// Texts are compared line by line, and lines that changed are compared word by word, so that small
+// changes stand out within longer output.
+
+package lib
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Represents the kind of a difference.
+type diffKind int
+
+// Difference kind constants.
+const (
+	DIFF_EQUAL  diffKind = iota // Token is in both texts.
+	DIFF_DELETE                 // Token is only in the old text.
+	DIFF_INSERT                 // Token is only in the new text.
+)
+
+// Misc. constants.
+const (
+	DIFF_MAX_CELLS = 1 << 22 // Largest comparison to search for common tokens, in token pairs.
+)
+
+var (
+	diffWordPattern = regexp.MustCompile(`\s+|\S+`) // Words, keeping the space between them.
+)
+
+// A single token of a difference.
+type diffOp struct {
+	kind diffKind // How the token differs.
+	text string   // The token itself.
+}
+
+// Finds the tokens deleted from and inserted into one sequence to produce another, based on their
+// longest common subsequence. Deletions come before insertions where they neighbor each other.
+// Sequences too large to search are considered entirely replaced.
+func diffTokens(from, to []string) (ops []diffOp) {
+	var (
+		prefix, suffix int // Lengths of common starts and ends.
+	)
+
+	// Skip over common starts and ends, since results tend to change only a little.
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	for _, token := range from[:prefix] {
+		ops = append(ops, diffOp{DIFF_EQUAL, token})
+	}
+
+	a, b := from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+	i, j := 0, 0
+	if len(a)*len(b) <= DIFF_MAX_CELLS {
+		// Lengths of the longest common subsequences of a[i:] and b[j:].
+		lengths := make([][]int, len(a)+1)
+		for i := range lengths {
+			lengths[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				} else {
+					lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+				}
+			}
+		}
+
+		for i < len(a) && j < len(b) {
+			switch {
+			case a[i] == b[j]:
+				ops = append(ops, diffOp{DIFF_EQUAL, a[i]})
+				i++
+				j++
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				ops = append(ops, diffOp{DIFF_DELETE, a[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{DIFF_INSERT, b[j]})
+				j++
+			}
+		}
+	}
+	for _, token := range a[i:] {
+		ops = append(ops, diffOp{DIFF_DELETE, token})
+	}
+	for _, token := range b[j:] {
+		ops = append(ops, diffOp{DIFF_INSERT, token})
+	}
+
+	for _, token := range from[len(from)-suffix:] {
+		ops = append(ops, diffOp{DIFF_EQUAL, token})
+	}
+
+	return
+}
+
+// Splits text into lines for comparison.
+func diffLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// Writes a changed line, highlighting the words that changed. Only words of the given kind, along
+// with words in common, are written.
+func diffRenderWords(out *strings.Builder, ops []diffOp, kind diffKind, color string) {
+	for _, op := range ops {
+		switch op.kind {
+		case DIFF_EQUAL:
+			out.WriteString(tview.Escape(op.text))
+		case kind:
+			out.WriteString("[" + color + "::r]" + tview.Escape(op.text) + "[-::-]")
+		}
+	}
+	out.WriteString("\n")
+}
+
+// Describes the differences between two texts, marked up with tview color tags. Removed lines are
+// red and added lines are green, and lines that changed highlight the words that changed.
+func diffRender(from, to string) string {
+	var (
+		out strings.Builder // Rendered differences.
+
+		ops = diffTokens(diffLines(from), diffLines(to)) // Line differences.
+	)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == DIFF_EQUAL {
+			out.WriteString("  " + tview.Escape(ops[i].text) + "\n")
+			i++
+			continue
+		}
+
+		// Gather neighboring changes, pairing removed and added lines as changed lines.
+		var deleted, inserted []string // Lines removed and added.
+		for ; i < len(ops) && ops[i].kind != DIFF_EQUAL; i++ {
+			if ops[i].kind == DIFF_DELETE {
+				deleted = append(deleted, ops[i].text)
+			} else {
+				inserted = append(inserted, ops[i].text)
+			}
+		}
+		for j, line := range deleted {
+			out.WriteString("[red]-[-] ")
+			if j < len(inserted) {
+				diffRenderWords(&out, diffTokens(
+					diffWordPattern.FindAllString(line, -1),
+					diffWordPattern.FindAllString(inserted[j], -1),
+				), DIFF_DELETE, "red")
+			} else {
+				out.WriteString("[red]" + tview.Escape(line) + "[-]\n")
+			}
+		}
+		for j, line := range inserted {
+			out.WriteString("[green]+[-] ")
+			if j < len(deleted) {
+				diffRenderWords(&out, diffTokens(
+					diffWordPattern.FindAllString(deleted[j], -1),
+					diffWordPattern.FindAllString(line, -1),
+				), DIFF_INSERT, "green")
+			} else {
+				out.WriteString("[green]" + tview.Escape(line) + "[-]\n")
+			}
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffTokens(t *testing.T) {
+	// It finds deleted and inserted tokens around common ones.
+	got := diffTokens([]string{"a", "b", "c", "d"}, []string{"a", "c", "e", "d"})
+	expected := []diffOp{
+		{DIFF_EQUAL, "a"},
+		{DIFF_DELETE, "b"},
+		{DIFF_EQUAL, "c"},
+		{DIFF_INSERT, "e"},
+		{DIFF_EQUAL, "d"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It puts deletions before insertions.
+	got = diffTokens([]string{"a"}, []string{"b"})
+	expected = []diffOp{{DIFF_DELETE, "a"}, {DIFF_INSERT, "b"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It handles empty sequences.
+	got = diffTokens([]string{}, []string{"a"})
+	expected = []diffOp{{DIFF_INSERT, "a"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+func TestDiffRender(t *testing.T) {
+	// It marks removed and added lines, highlighting changed words.
+	got := diffRender("up 1 day\nload 0.5\n[old]\n", "up 1 day\nload 0.7\n")
+	expected := "  up 1 day\n" +
+		"[red]-[-] load [red::r]0.5[-::-]\n" +
+		"[red]-[-] [red][old[][-]\n" +
+		"[green]+[-] load [green::r]0.7[-::-]\n"
+	if got != expected {
+		t.Errorf("Got: %q Expected: %q\n", got, expected)
+	}
+}
@@ -7,11 +7,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/widgets/linechart"
 	"github.com/mum4k/termdash/widgets/sparkline"
+	"github.com/mum4k/termdash/widgets/text"
 	"github.com/rivo/tview"
 
 	"github.com/spacez320/shui/pkg/storage"
@@ -22,6 +28,8 @@ type DisplayConfig struct {
 	HelpSize, LogsSize, ResultsSize                                          int  // Proportional size of widgets.
 	OuterPaddingBottom, OuterPaddingLeft, OuterPaddingRight, OuterPaddingTop int  // Padding for the full display.
 	ShowHelp, ShowLogs, ShowStatus                                           bool // Whether or not to show widgets.
+	ChartPrecision                                                           int  // Decimal places for chart axes.
+	DashboardColumns                                                         int  // Panels in each row of dashboards.
 	TablePadding                                                             int  // Padding for table cells in table displays.
 }
 
@@ -45,14 +53,29 @@ const (
 
 // Display mode constants.
 const (
-	DISPLAY_MODE_RAW    DisplayMode = iota // For running in 'raw' display mode.
-	DISPLAY_MODE_STREAM                    // For running in 'stream' display mode.
-	DISPLAY_MODE_TABLE                     // For running in 'table' display mode.
-	DISPLAY_MODE_GRAPH                     // For running in 'graph' display mode.
+	DISPLAY_MODE_RAW       DisplayMode = iota // For running in 'raw' display mode.
+	DISPLAY_MODE_STREAM                       // For running in 'stream' display mode.
+	DISPLAY_MODE_TABLE                        // For running in 'table' display mode.
+	DISPLAY_MODE_GRAPH                        // For running in 'graph' display mode.
+	DISPLAY_MODE_CHART                        // For running in 'chart' display mode.
+	DISPLAY_MODE_DASHBOARD                    // For running in 'dashboard' display mode.
+	DISPLAY_MODE_DIFF                         // For running in 'diff' display mode.
+)
+
+// Represents a way of moving through result history.
+type historyAction int
+
+// History action constants.
+const (
+	HISTORY_BACK    historyAction = iota // Move to the previous result.
+	HISTORY_FORWARD                      // Move to the next result.
+	HISTORY_MARK                         // Compare against the current result, or stop doing so.
+	HISTORY_FOLLOW                       // Move to the latest result and stay there.
 )
 
 // Defaults for display configs.
 const (
+	DEFAULT_CHART_PRECISION      = 2
 	DEFAULT_HELP_SIZE            = 10
 	DEFAULT_LOGS_SIZE            = 15
 	DEFAULT_OUTER_PADDING_BOTTOM = 5
@@ -65,7 +88,27 @@ const (
 
 // Misc. constants.
 const (
-	HELP_TEXT = "(ESC) Quit | (Space) Pause | (Tab) Next Display | (n) Next Query"
+	CHART_SIZE        = 1024       // Number of points kept for charts.
+	CHART_TIME_FORMAT = "15:04:05" // Format of chart time axis labels.
+	HELP_TEXT         = "(ESC) Quit | (Space) Pause | (Tab) Next Display | (n) Next Query"
+	HELP_TEXT_DETACH  = " | (d) Detach | (S) Stop" // Additional help when reading another Shui.
+
+	// Additional help for displays with history.
+	HELP_TEXT_HISTORY = " | ([/]) Previous/Next | (m) Mark | (f) Follow"
+)
+
+var (
+	// Colors of chart series, in order of use.
+	chartColors = []cell.Color{
+		cell.ColorGreen,
+		cell.ColorBlue,
+		cell.ColorYellow,
+		cell.ColorMagenta,
+		cell.ColorCyan,
+		cell.ColorRed,
+		cell.ColorWhite,
+	}
+	metaLabels = []string{"exit code", "duration"} // Labels for result metadata, in table displays.
 )
 
 var (
@@ -74,16 +117,31 @@ var (
 		DISPLAY_MODE_STREAM,
 		DISPLAY_MODE_TABLE,
 		DISPLAY_MODE_GRAPH,
+		DISPLAY_MODE_CHART,
+		DISPLAY_MODE_DASHBOARD,
+		DISPLAY_MODE_DIFF,
 	} // Display modes considered for use in the current session.
 	interruptChan = make(chan bool) // Channel for interrupting displays.
 
 	// Mapping of display mode constants to a common display mode name.
 	DisplayModes = map[DisplayMode]string{
-		DISPLAY_MODE_RAW:    "raw", // First to serve as the 'default.'
-		DISPLAY_MODE_STREAM: "stream",
-		DISPLAY_MODE_TABLE:  "table",
-		DISPLAY_MODE_GRAPH:  "graph",
+		DISPLAY_MODE_RAW:       "raw", // First to serve as the 'default.'
+		DISPLAY_MODE_STREAM:    "stream",
+		DISPLAY_MODE_TABLE:     "table",
+		DISPLAY_MODE_GRAPH:     "graph",
+		DISPLAY_MODE_CHART:     "chart",
+		DISPLAY_MODE_DASHBOARD: "dashboard",
+		DISPLAY_MODE_DIFF:      "diff",
 	}
+
+	// Mapping of keys to history actions.
+	historyActionKeys = map[rune]historyAction{
+		'[': HISTORY_BACK,
+		']': HISTORY_FORWARD,
+		'm': HISTORY_MARK,
+		'f': HISTORY_FOLLOW,
+	}
+	historyChan = make(chan historyAction, 1) // Channel for moving through history.
 )
 
 // Starts the display. Applies contextual logic depending on the provided display driver. Expects a
@@ -103,11 +161,77 @@ func display(driver DisplayDriver, displayUpdateFunc func()) {
 	}
 }
 
+// Describes available keys.
+func helpText() string {
+	if client != nil {
+		return HELP_TEXT + HELP_TEXT_DETACH
+	}
+
+	return HELP_TEXT
+}
+
 // Clean-up display logic when fully quitting.
 func displayQuit() {
 	close(interruptChan)
 }
 
+// Describes the status of a result, including execution metadata, for status displays.
+func resultStatusText(result storage.Result) string {
+	return fmt.Sprintf(
+		"%s | exit %d | %v",
+		result.Status,
+		result.ExitCode,
+		result.Duration.Round(time.Millisecond),
+	)
+}
+
+// Describes delivery of results to Elasticsearch, for status displays. Counts other than indexed
+// documents are left out while zero, since status displays have little room. Empty if not enabled.
+func deliveryStatusText() string {
+	if elasticsearch == nil {
+		return ""
+	}
+
+	stats := elasticsearch.Stats()
+	counts := []string{fmt.Sprintf("%d indexed", stats.Indexed)}
+	for _, count := range []struct {
+		name  string
+		value int
+	}{
+		{"pending", stats.Pending},
+		{"retried", stats.Retried},
+		{"failed", stats.Failed},
+	} {
+		if count.value > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count.value, count.name))
+		}
+	}
+
+	return "es: " + strings.Join(counts, ", ")
+}
+
+// Describes a result for stream displays, following values with any error output. Each row of
+// values is on a line of its own.
+func resultStreamText(result storage.Result) string {
+	var (
+		rows = make([]string, 0, len(result.AllRows())) // Descriptions of each row.
+	)
+
+	for _, row := range result.AllRows() {
+		rows = append(rows, fmt.Sprint(row))
+	}
+	if result.Stderr != "" {
+		return fmt.Sprintf("%s (stderr: %s)", strings.Join(rows, "\n"), result.Stderr)
+	}
+
+	return strings.Join(rows, "\n")
+}
+
+// Waits a tiny bit while there are no new results, to avoid busy waiting in display updates.
+func waitForResults() {
+	time.Sleep(time.Duration(10) * time.Millisecond)
+}
+
 // Fetches a display mode value from its common name.
 func DisplayModeFromString(s string) (DisplayMode, error) {
 	for k, v := range DisplayModes {
@@ -122,6 +246,8 @@ func DisplayModeFromString(s string) (DisplayMode, error) {
 // Creates a default display config.
 func NewDisplayConfig() *DisplayConfig {
 	return &DisplayConfig{
+		ChartPrecision:     DEFAULT_CHART_PRECISION,
+		DashboardColumns:   DEFAULT_DASHBOARD_COLUMNS,
 		HelpSize:           DEFAULT_HELP_SIZE,
 		LogsSize:           DEFAULT_LOGS_SIZE,
 		OuterPaddingBottom: DEFAULT_OUTER_PADDING_BOTTOM,
@@ -149,7 +275,7 @@ func RawDisplay(query string, filters, expressions []string) {
 	reader.Dec()
 
 	// Load existing results.
-	for _, result := range store.GetToIndex(query, filters, reader) {
+	store.EachToIndex(query, filters, reader, func(result storage.Result) bool {
 		// Execute any expressions.
 		if len(expressions) > 0 {
 			result = ExprResult(query, expressions, result, prevResult)
@@ -158,7 +284,8 @@ func RawDisplay(query string, filters, expressions []string) {
 		fmt.Println(result)
 
 		prevResult = result
-	}
+		return true
+	})
 
 	// Load new results.
 	for {
@@ -199,17 +326,19 @@ func StreamDisplay(query string, filters, expressions []string, displayConfig *D
 			)
 
 			// Load existing results.
-			for _, result := range store.GetToIndex(query, filters, reader) {
+			store.EachToIndex(query, filters, reader, func(result storage.Result) bool {
 				// Execute any expressions.
 				if len(expressions) > 0 {
 					result = ExprResult(query, expressions, result, prevResult)
 				}
 
 				// Display the next result.
-				fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), result.Values)
+				fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), resultStreamText(result))
+				updateDisplayTviewStatus(&widgets, result)
 
 				prevResult = result
-			}
+				return true
+			})
 
 			// Load new results.
 			for {
@@ -229,7 +358,8 @@ func StreamDisplay(query string, filters, expressions []string, displayConfig *D
 					}
 
 					// We can display the next result.
-					fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), nextResult.Values)
+					fmt.Fprintln(widgets.resultsWidget.(*tview.TextView), resultStreamText(nextResult))
+					updateDisplayTviewStatus(&widgets, nextResult)
 
 					prevResult = nextResult
 				}
@@ -251,12 +381,54 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 			case float64:
 				cellContent = strconv.FormatFloat(value.(float64), 'f', -1, 64)
 			default:
-				cellContent = value.(string)
+				cellContent = fmt.Sprint(value)
 			}
 			return
 		} // Parses results for displaying in table cells.
 		reader           = readerIndexes[query]                            // Reader index for the query.
 		tableCellPadding = strings.Repeat(" ", displayConfig.TablePadding) // Padding to add to table cell content.
+		tabular          = config.QueryConfigs[query].Key != ""            // Whether results are sets of rows.
+		metaCellsSetter  = func(row *tview.Table, i int, result storage.Result) {
+			row.SetCellSimple(
+				i, len(labels), tableCellPadding+strconv.Itoa(result.ExitCode)+tableCellPadding)
+			row.SetCellSimple(
+				i,
+				len(labels)+1,
+				tableCellPadding+result.Duration.Round(time.Millisecond).String()+tableCellPadding,
+			)
+		} // Adds result metadata to the cells following result values.
+		headerSetter = func(table *tview.Table) {
+			for j, label := range labels {
+				table.SetCellSimple(0, j, tableCellPadding+label+tableCellPadding)
+			}
+			for j, label := range metaLabels {
+				table.SetCellSimple(0, len(labels)+j, tableCellPadding+label+tableCellPadding)
+			}
+		} // Sets the header row from labels.
+		rowsSetter = func(table *tview.Table, i int, result storage.Result) int {
+			if len(result.Labels) > 0 && !slices.Equal(labels, result.Labels) {
+				// Values from expressions may have labels of their own.
+				labels = result.Labels
+				headerSetter(table)
+			}
+			rows := result.AllRows()
+			if tabular = tabular || len(rows) > 1; tabular {
+				// Show only the latest set of rows, below the header.
+				for table.GetRowCount() > 1 {
+					table.RemoveRow(1)
+				}
+				i = 1
+			}
+			for _, values := range rows {
+				row := table.InsertRow(i) // Row to contain the result.
+				for j, value := range values {
+					row.SetCellSimple(i, j, tableCellPadding+cellContentParser(value)+tableCellPadding)
+				}
+				metaCellsSetter(row, i, result)
+				i += 1
+			}
+			return i
+		} // Adds the rows of a result to the table, returning the next row index.
 	)
 
 	// Wait for the first result to appear to synchronize storage.
@@ -265,7 +437,7 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 
 	// Determine labels to display as part of the table, based on the presence of expressions.
 	if len(expressions) > 0 {
-		// Expressions provide single-value results--apply a generic label.
+		// Expressions provide single-value results unless they label their own--apply a generic label.
 		labels = []string{"results"}
 	} else {
 		// Get labels according to filters.
@@ -288,10 +460,7 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 			// Load table header.
 			appTview.QueueUpdateDraw(func() {
 				// Row to contain the labels.
-				headerRow := widgets.resultsWidget.(*tview.Table).InsertRow(i)
-				for j, label := range labels {
-					headerRow.SetCellSimple(i, j, tableCellPadding+label+tableCellPadding)
-				}
+				headerSetter(widgets.resultsWidget.(*tview.Table).InsertRow(i))
 			})
 			i += 1
 
@@ -299,26 +468,22 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 			for _, result := range GetPrevResults(query, filters) {
 				// We can display the next result.
 				appTview.QueueUpdateDraw(func() {
+					updateDisplayTviewStatus(&widgets, result)
 					if result.IsEmptyValues() {
 						// Ignore empty results.
 						slog.Warn("Cannot display an empty result", "query", query)
 						return
 					}
 
-					row := widgets.resultsWidget.(*tview.Table).InsertRow(i) // Row to contain the result.
-
 					// Execute any expressions.
 					if len(expressions) > 0 {
 						result = ExprResult(query, expressions, result, prevResult)
 					}
 
-					// Load results into the next row.
-					for j, value := range result.Values {
-						row.SetCellSimple(i, j, tableCellPadding+cellContentParser(value)+tableCellPadding)
-					}
+					// Load results into the next rows.
+					i = rowsSetter(widgets.resultsWidget.(*tview.Table), i, result)
 
 					prevResult = result
-					i += 1
 				})
 			}
 
@@ -337,25 +502,21 @@ func TableDisplay(query string, filters, expressions []string, displayConfig *Di
 					appTview.QueueUpdateDraw(func() {
 						// Get a result and execute expressions.
 						nextResult = GetResult(query, filters)
+						updateDisplayTviewStatus(&widgets, nextResult)
 						if nextResult.IsEmptyValues() {
 							// Ignore empty results.
 							slog.Warn("Cannot display an empty result", "query", query)
 							return
 						}
 
-						row := widgets.resultsWidget.(*tview.Table).InsertRow(i) // Row to contain the result.
-
 						if len(expressions) > 0 {
 							nextResult = ExprResult(query, expressions, nextResult, prevResult)
 						}
 
 						// Display something if we have something.
-						for j, value := range nextResult.Values {
-							row.SetCellSimple(i, j, tableCellPadding+cellContentParser(value)+tableCellPadding)
-						}
+						i = rowsSetter(widgets.resultsWidget.(*tview.Table), i, nextResult)
 
 						prevResult = nextResult
-						i += 1
 					})
 				}
 			}
@@ -402,6 +563,10 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 	)
 	e(err)
 
+	// The status view is updated by the display function, so it must exist before it starts.
+	widgets.statusWidget, err = text.New()
+	e(err)
+
 	// Start the display.
 	display(
 		DISPLAY_TERMDASH,
@@ -412,18 +577,19 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 			)
 
 			// Load existing results.
-			for _, result := range store.GetToIndex(query, []string{filter}, reader) {
+			store.EachToIndex(query, []string{filter}, reader, func(result storage.Result) bool {
+				updateDisplayTermdashStatus(&widgets, result)
 				if result.IsEmptyValues() {
 					// Ignore empty results.
 					slog.Warn("Cannot display an empty result", "query", query)
-					continue
+					return true
 				}
 
 				// Execute any expressions.
 				if len(expressions) > 0 {
 					result = ExprResult(query, expressions, result, prevResult)
-					// Expressions always return a string, so always try to convert it into a float.
-					value, _ = strconv.ParseFloat(result.Values[0].(string), 10)
+					// Expressions may output strings, so convert values into numbers.
+					value, _ = chartValue(result.Values[0])
 					widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
 				} else {
 					// Use typless assignment to account for various numeric values.
@@ -432,7 +598,8 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 				}
 
 				prevResult = result
-			}
+				return true
+			})
 
 			// Load new results.
 			for {
@@ -447,6 +614,7 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 				default:
 					// Get a result and execute expressions.
 					nextResult = GetResult(query, []string{filter})
+					updateDisplayTermdashStatus(&widgets, nextResult)
 
 					if nextResult.IsEmptyValues() {
 						// Ignore empty results.
@@ -456,8 +624,8 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 
 					if len(expressions) > 0 {
 						nextResult = ExprResult(query, expressions, nextResult, prevResult)
-						// Expressions always return a string, so always try to convert it into a float.
-						value, _ = strconv.ParseFloat(nextResult.Values[0].(string), 10)
+						// Expressions may output strings, so convert values into numbers.
+						value, _ = chartValue(nextResult.Values[0])
 						widgets.resultsWidget.(*sparkline.SparkLine).Add(sparkParser(value))
 					} else {
 						// Use typless assignment to account for various numeric values.
@@ -481,3 +649,541 @@ func GraphDisplay(query string, filter string, expressions []string, displayConf
 		displayConfig,
 	)
 }
+
+// Values for a line chart, where all series share a time axis.
+type chartData struct {
+	labels []string    // Name of each series.
+	size   int         // Maximum number of points to keep.
+	times  []time.Time // Time of each point.
+	values [][]float64 // Values of each series, corresponding by index to times.
+}
+
+// Creates chart data for named series, keeping up to a number of points.
+func newChartData(labels []string, size int) *chartData {
+	return &chartData{
+		labels: labels,
+		size:   size,
+		values: make([][]float64, len(labels)),
+	}
+}
+
+// Adds a point in time, given values for series by index. Series without a new value carry their
+// last value forward, or have no value if they have none yet.
+func (c *chartData) add(t time.Time, values map[int]float64) {
+	(*c).times = append((*c).times, t)
+	for i := range (*c).values {
+		value, ok := values[i]
+		if !ok {
+			value = math.NaN()
+			if len((*c).values[i]) > 0 {
+				value = (*c).values[i][len((*c).values[i])-1]
+			}
+		}
+		(*c).values[i] = append((*c).values[i], value)
+	}
+
+	// Discard the oldest points.
+	if drop := len((*c).times) - (*c).size; drop > 0 {
+		(*c).times = (*c).times[drop:]
+		for i := range (*c).values {
+			(*c).values[i] = (*c).values[i][drop:]
+		}
+	}
+}
+
+// Finds the index of a series by its label, adding the series if it doesn't exist. Added series
+// have no values for existing points.
+func (c *chartData) series(label string) (index int, added bool) {
+	if index = slices.Index((*c).labels, label); index >= 0 {
+		return
+	}
+
+	values := make([]float64, len((*c).times))
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	(*c).labels = append((*c).labels, label)
+	(*c).values = append((*c).values, values)
+
+	return len((*c).labels) - 1, true
+}
+
+// Labels for the time axis, keyed by point.
+func (c *chartData) xLabels() map[int]string {
+	labels := make(map[int]string, len((*c).times))
+	for i, t := range (*c).times {
+		labels[i] = t.Format(CHART_TIME_FORMAT)
+	}
+
+	return labels
+}
+
+// Converts a result value into a number for charting. Strings, such as expression output, are
+// parsed. Returns false for values that aren't numbers.
+func chartValue(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case int64:
+		return float64(value.(int64)), true
+	case float64:
+		return value.(float64), true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value.(string)), 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// Creates a line chart widget with a time axis.
+func newChartWidget(displayConfig *DisplayConfig) (*linechart.LineChart, error) {
+	return linechart.New(
+		linechart.AxesCellOpts(cell.FgColor(cell.ColorWhite)),
+		linechart.XAxisUnscaled(),
+		linechart.YAxisAdaptive(),
+		linechart.YAxisFormattedValues(
+			linechart.ValueFormatterSuffix(displayConfig.ChartPrecision, "")),
+	)
+}
+
+// Creates a legend naming each chart series in its color.
+func newChartLegend(labels []string) (legend *text.Text, err error) {
+	if legend, err = text.New(text.WrapAtWords()); err != nil {
+		return
+	}
+	err = writeChartLegend(legend, labels)
+
+	return
+}
+
+// Writes the names of chart series to a legend, replacing any already there.
+func writeChartLegend(legend *text.Text, labels []string) (err error) {
+	legend.Reset()
+	for i, label := range labels {
+		err = legend.Write(
+			fmt.Sprintf("━ %s  ", label),
+			text.WriteCellOpts(cell.FgColor(chartColors[i%len(chartColors)])),
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Draws all series of chart data.
+func drawChart(lineChart *linechart.LineChart, data *chartData) (err error) {
+	xLabels := data.xLabels()
+	for i, label := range (*data).labels {
+		err = lineChart.Series(
+			label,
+			(*data).values[i],
+			linechart.SeriesCellOpts(cell.FgColor(chartColors[i%len(chartColors)])),
+			linechart.SeriesXLabels(xLabels),
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Presents results as a line chart of numeric values over time. Each filter of the query becomes a
+// series. Without filters, each query's first value becomes a series instead, overlaying queries.
+// Expressions produce a single series for each query. Keyed queries instead have series for each
+// key, added as keys appear.
+func ChartDisplay(
+	query string,
+	queries, filters, expressions []string,
+	displayConfig *DisplayConfig,
+) {
+	var (
+		chartQueries []string   // Queries with values in the chart.
+		data         *chartData // Values to draw.
+		err          error      // General error holder.
+		seriesLabels []string   // Name of each series.
+
+		keyed       = config.QueryConfigs[query].Key != "" // Whether series are by key.
+		prevResults = make(map[[2]string]storage.Result)   // Previous results of each query and key.
+		widgets     = termdashWidgets{}                    // Widgets for displaying.
+	)
+
+	// Determine the series to chart.
+	if len(filters) > 0 || keyed {
+		chartQueries = []string{query}
+	} else {
+		chartQueries = queries
+	}
+	for _, chartQuery := range chartQueries {
+		switch {
+		case keyed:
+			// Series are added as keys appear.
+		case len(filters) > 0 && len(expressions) == 0:
+			seriesLabels = append(seriesLabels, filters...)
+		default:
+			seriesLabels = append(seriesLabels, chartQuery)
+		}
+	}
+	data = newChartData(seriesLabels, CHART_SIZE)
+
+	// Finds the values of a result for the series of its query, along with the series names.
+	resultValues := func(
+		resultQuery string,
+		result storage.Result,
+	) (labels []string, values []float64) {
+		keys, keyedResults := keyedResults(resultQuery, result)
+		for i, result := range keyedResults {
+			// Names a series, distinguishing those of each key.
+			name := func(label string) string {
+				switch {
+				case keys[i] == "":
+					return label
+				case len(filters) > 1 && len(expressions) == 0:
+					return keys[i] + " " + label
+				default:
+					return keys[i]
+				}
+			}
+			// Adds a value to a series.
+			add := func(label string, value interface{}) {
+				number, ok := chartValue(value)
+				if !ok {
+					number = math.NaN()
+				}
+				labels, values = append(labels, name(label)), append(values, number)
+			}
+
+			if len(expressions) > 0 {
+				prevKey := [2]string{resultQuery, keys[i]}
+				result = ExprResult(resultQuery, expressions, result, prevResults[prevKey])
+				prevResults[prevKey] = result
+			} else if len(filters) > 0 {
+				resultLabels := store.GetResultLabels(resultQuery, result)
+				for _, filter := range filters {
+					index := slices.Index(resultLabels, filter)
+					if index >= 0 && index < len(result.Values) {
+						add(filter, result.Values[index])
+					}
+				}
+				continue
+			}
+
+			// Chart the first value, other than any key.
+			index := 0
+			if len(expressions) == 0 && keyIndex(resultQuery, result) == 0 {
+				index = 1
+			}
+			if index < len(result.Values) {
+				add(resultQuery, result.Values[index])
+			}
+		}
+		return
+	}
+
+	// Adds values of series at a point in time.
+	addValues := func(t time.Time, labels []string, values []float64) {
+		var (
+			added   bool                                         // Whether any series are new.
+			indexes = make(map[int]float64, len((*data).labels)) // Values by series index.
+		)
+
+		if keyed {
+			// Keys missing from a result no longer have values.
+			for i := range (*data).labels {
+				indexes[i] = math.NaN()
+			}
+		}
+		for i, label := range labels {
+			index, isNew := data.series(label)
+			indexes[index], added = values[i], added || isNew
+		}
+		data.add(t, indexes)
+
+		if added {
+			e(writeChartLegend(widgets.legendWidget, (*data).labels))
+		}
+	}
+
+	// Wait for the first result to appear to synchronize storage.
+	GetResultWait(query)
+	readerIndexes[query].Dec()
+
+	// Initialize the results view.
+	widgets.resultsWidget, err = newChartWidget(displayConfig)
+	e(err)
+	widgets.legendWidget, err = newChartLegend(seriesLabels)
+	e(err)
+	widgets.statusWidget, err = text.New()
+	e(err)
+
+	// Start the display.
+	display(
+		DISPLAY_TERMDASH,
+		func() {
+			type point struct {
+				time   time.Time // Time of the result.
+				labels []string  // Names of series.
+				values []float64 // Values of series.
+			} // Point to add to the chart.
+			var (
+				points []point // Existing results of all charted queries.
+			)
+
+			// Load existing results, ordered in time across queries.
+			for _, chartQuery := range chartQueries {
+				store.EachToIndex(
+					chartQuery,
+					[]string{},
+					readerIndexes[chartQuery],
+					func(result storage.Result) bool {
+						if chartQuery == query {
+							updateDisplayTermdashStatus(&widgets, result)
+						}
+						if !result.IsEmptyValues() {
+							labels, values := resultValues(chartQuery, result)
+							points = append(points, point{result.Time, labels, values})
+						}
+						return true
+					},
+				)
+			}
+			sort.SliceStable(points, func(i, j int) bool { return points[i].time.Before(points[j].time) })
+			for _, point := range points {
+				addValues(point.time, point.labels, point.values)
+			}
+			e(drawChart(widgets.resultsWidget.(*linechart.LineChart), data))
+
+			// Load new results.
+			for {
+				// Listen for an interrupt to stop result consumption for some display change.
+				select {
+				case <-interruptChan:
+					// We've received an interrupt.
+					return
+				case <-pauseDisplayChan:
+					// We've received a pause and need to wait for an unpause.
+					<-pauseDisplayChan
+				default:
+					received := false // Whether any query had a new result.
+
+					for _, chartQuery := range chartQueries {
+						nextResult := store.NextOrEmpty(
+							subscriptions[chartQuery], readerIndexes[chartQuery])
+						if nextResult.IsEmpty() {
+							continue
+						}
+						received = true
+
+						if chartQuery == query {
+							updateDisplayTermdashStatus(&widgets, nextResult)
+						}
+						if nextResult.IsEmptyValues() {
+							// Ignore empty results.
+							slog.Warn("Cannot display an empty result", "query", chartQuery)
+							continue
+						}
+						labels, values := resultValues(chartQuery, nextResult)
+						addValues(nextResult.Time, labels, values)
+					}
+
+					if received {
+						e(drawChart(widgets.resultsWidget.(*linechart.LineChart), data))
+					} else {
+						waitForResults()
+					}
+				}
+			}
+		},
+	)
+
+	// Initialize the display. This must happen after the display function is invoked, otherwise data
+	// will never appear.
+	initDisplayTermdash(widgets, query, filters, store.GetLabels(query, filters), displayConfig)
+}
+
+// Describes a result for diff displays. Unless filters or expressions apply, the result is compared
+// as it was output.
+func resultDiffText(result storage.Result, transformed bool) string {
+	if transformed || result.Value == "" {
+		return resultStreamText(result)
+	}
+
+	return result.Value
+}
+
+// Position of a diff display in the history of a query. Positions are result times rather than
+// indexes, so that they still refer to the same results after retention removes older ones.
+type diffHistory struct {
+	following bool      // Whether to move to new results as they arrive.
+	mark      time.Time // Result compared against, if any.
+	query     string    // Query of the results.
+	to        time.Time // Result being compared.
+}
+
+// Creates a history position following the latest result of a query.
+func newDiffHistory(query string) *diffHistory {
+	history := &diffHistory{following: true, query: query}
+	history.latest()
+
+	return history
+}
+
+// Moves to the latest result.
+func (h *diffHistory) latest() {
+	if latest := store.GetLatest((*h).query, 1); len(latest) > 0 {
+		(*h).to = latest[0].Time
+	}
+}
+
+// Moves through history.
+func (h *diffHistory) move(action historyAction) {
+	switch action {
+	case HISTORY_BACK:
+		(*h).following = false
+		if before := store.GetBefore((*h).query, (*h).to); len(before) > 0 {
+			(*h).to = before[len(before)-1].Time
+		}
+	case HISTORY_FORWARD:
+		after := store.GetAfter((*h).query, (*h).to)
+		if len(after) > 0 {
+			(*h).to = after[0].Time
+		}
+		(*h).following = len(after) <= 1
+	case HISTORY_MARK:
+		if (*h).mark.IsZero() {
+			(*h).mark = (*h).to
+		} else {
+			(*h).mark = time.Time{}
+		}
+	case HISTORY_FOLLOW:
+		(*h).following = true
+		(*h).latest()
+	}
+}
+
+// Provides the result being compared and the one it is compared against, which is the mark or
+// otherwise the result before it. A result removed by retention is replaced by the oldest one after
+// it, while a mark removed by retention is cleared. Returns false if there is nothing to compare.
+func (h *diffHistory) results() (from, to storage.Result, ok bool) {
+	if to = store.Get((*h).query, (*h).to); to.IsEmpty() {
+		after := store.GetAfter((*h).query, (*h).to)
+		if len(after) == 0 {
+			return
+		}
+		to, (*h).to = after[0], after[0].Time
+	}
+	if !(*h).mark.IsZero() {
+		if from = store.Get((*h).query, (*h).mark); from.IsEmpty() {
+			(*h).mark = time.Time{}
+		}
+	} else if before := store.GetBefore((*h).query, (*h).to); len(before) > 0 {
+		from = before[len(before)-1]
+	}
+
+	return from, to, true
+}
+
+// Presents the differences between each result and the one before it. Any result may instead be
+// marked to compare later results against, and earlier results may be stepped back through.
+func DiffDisplay(query string, filters, expressions []string, displayConfig *DisplayConfig) {
+	var (
+		widgets tviewWidgets // Widgets produced by tview.
+
+		reader = readerIndexes[query] // Reader index for the query.
+	)
+
+	// Wait for the first result to appear to synchronize storage.
+	GetResultWait(query)
+	reader.Dec()
+
+	// Initialize the display.
+	widgets = initDisplayTviewDiff(query, filters, store.GetLabels(query, []string{}), displayConfig)
+
+	// Describes a result, filtering it and executing any expressions.
+	describe := func(result storage.Result) string {
+		var (
+			prevResult storage.Result // Result before, for expressions requiring history.
+		)
+
+		result = store.FilterResult(query, filters, result)
+		if len(expressions) > 0 {
+			if before := store.GetBefore(query, result.Time); len(before) > 0 {
+				prevResult = store.FilterResult(query, filters, before[len(before)-1])
+			}
+			result = ExprResult(query, expressions, result, prevResult)
+		}
+
+		return resultDiffText(result, len(filters) > 0 || len(expressions) > 0)
+	}
+
+	// Start the display.
+	display(
+		DISPLAY_TVIEW,
+		func() {
+			var (
+				history = newDiffHistory(query) // Position in history.
+				paused  = false                 // Whether new results are being held back.
+			)
+
+			// Shows the differences for the current position in history.
+			update := func() {
+				var (
+					fromText, text string // Descriptions of compared results.
+					fromTime       string // When the result compared against happened.
+				)
+
+				from, to, ok := history.results()
+				if !ok {
+					return
+				}
+				if !from.IsEmpty() {
+					fromText, fromTime = describe(from), from.Time.Format(time.DateTime)
+				}
+				text = diffRender(fromText, describe(to))
+
+				appTview.QueueUpdateDraw(func() {
+					widgets.resultsWidget.(*tview.TextView).SetText(text).ScrollToBeginning()
+					widgets.resultsWidget.(*tview.TextView).SetTitle(fmt.Sprintf(
+						"Results (%s → %s)", fromTime, to.Time.Format(time.DateTime)))
+				})
+				updateDisplayTviewStatus(&widgets, to)
+			}
+
+			// Discard history actions from before this display.
+			select {
+			case <-historyChan:
+			default:
+			}
+			update()
+
+			for {
+				// Listen for an interrupt to stop result consumption for some display change.
+				select {
+				case <-interruptChan:
+					// We've received an interrupt.
+					return
+				case <-pauseDisplayChan:
+					// History may still be moved through while paused.
+					paused = !paused
+				case action := <-historyChan:
+					history.move(action)
+					update()
+				default:
+					if paused {
+						time.Sleep(time.Duration(10) * time.Millisecond)
+						continue
+					}
+					if nextResult := store.NextOrEmpty(subscriptions[query], reader); nextResult.IsEmpty() {
+						waitForResults()
+						continue
+					}
+					if history.following {
+						history.latest()
+						update()
+					}
+				}
+			}
+		},
+	)
+}
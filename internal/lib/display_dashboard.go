@@ -0,0 +1,383 @@
+//
+// Dashboard display, presenting several queries at once.
+//
+// Each query gets a panel of its own, laid out in a grid. Panels use their own display mode, set
+// for each query in configuration files, and are always drawn with termdash.
+
+package lib
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/widgetapi"
+	"github.com/mum4k/termdash/widgets/linechart"
+	"github.com/mum4k/termdash/widgets/sparkline"
+	"github.com/mum4k/termdash/widgets/text"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+// Defaults for dashboards.
+const (
+	DEFAULT_DASHBOARD_COLUMNS = 3
+	DEFAULT_PANEL_DISPLAY     = DISPLAY_MODE_STREAM
+)
+
+var (
+	// Display modes that may be used for dashboard panels.
+	panelDisplayModes = []DisplayMode{
+		DISPLAY_MODE_STREAM,
+		DISPLAY_MODE_TABLE,
+		DISPLAY_MODE_GRAPH,
+		DISPLAY_MODE_CHART,
+	}
+)
+
+// Panel of a dashboard, presenting the results of a single query.
+type dashboardPanel struct {
+	chart       *chartData       // Values for chart panels.
+	displayMode DisplayMode      // How results are presented.
+	key         int              // Index of the key column of presented values, or -1 without one.
+	labels      []string         // Labels of presented values.
+	legend      *text.Text       // Legend for chart panels.
+	query       string           // Query presented.
+	widget      widgetapi.Widget // Widget presenting results.
+}
+
+// Creates a panel for a query, given labels of the values it will present.
+func newDashboardPanel(
+	query string,
+	displayMode DisplayMode,
+	labels []string,
+	displayConfig *DisplayConfig,
+) (panel *dashboardPanel, err error) {
+	panel = &dashboardPanel{displayMode: displayMode, key: -1, labels: labels, query: query}
+	if config.QueryConfigs[query].Key != "" {
+		(*panel).key = slices.Index(labels, config.QueryConfigs[query].Key)
+	}
+
+	switch displayMode {
+	case DISPLAY_MODE_TABLE:
+		(*panel).widget, err = text.New()
+	case DISPLAY_MODE_GRAPH:
+		(*panel).widget, err = sparkline.New(sparkline.Color(cell.ColorGreen))
+	case DISPLAY_MODE_CHART:
+		switch {
+		case (*panel).key >= 0:
+			// Series are added as keys appear.
+			labels = []string{}
+		case len(labels) == 0:
+			labels = []string{query}
+		}
+		(*panel).chart = newChartData(labels, CHART_SIZE)
+		if (*panel).legend, err = newChartLegend(labels); err != nil {
+			return
+		}
+		(*panel).widget, err = newChartWidget(displayConfig)
+	default:
+		(*panel).widget, err = text.New(text.RollContent())
+	}
+
+	return
+}
+
+// Presents a result.
+func (p *dashboardPanel) add(result storage.Result) (err error) {
+	switch (*p).displayMode {
+	case DISPLAY_MODE_TABLE:
+		// Tables only show the latest values.
+		var rows strings.Builder // Table contents.
+		if len(result.Rows) > 1 || (*p).key >= 0 {
+			// Results with several rows are aligned into columns beneath their labels.
+			columns := tabwriter.NewWriter(&rows, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(columns, strings.Join((*p).labels, "\t"))
+			for _, row := range result.AllRows() {
+				values := make([]string, len(row))
+				for i, value := range row {
+					values[i] = fmt.Sprint(value)
+				}
+				fmt.Fprintln(columns, strings.Join(values, "\t"))
+			}
+			columns.Flush()
+		} else {
+			for i, value := range result.Values {
+				label := fmt.Sprint(i)
+				if i < len((*p).labels) {
+					label = (*p).labels[i]
+				}
+				fmt.Fprintf(&rows, "%s: %v\n", label, value)
+			}
+		}
+		(*p).widget.(*text.Text).Reset()
+		err = (*p).widget.(*text.Text).Write(rows.String())
+	case DISPLAY_MODE_GRAPH:
+		if len(result.Values) == 0 {
+			return
+		}
+		if value, ok := chartValue(result.Values[0]); ok {
+			err = (*p).widget.(*sparkline.SparkLine).Add([]int{int(value)})
+		}
+	case DISPLAY_MODE_CHART:
+		if (*p).key >= 0 {
+			return p.addKeyed(result)
+		}
+		values := make(map[int]float64, len(result.Values))
+		for i, value := range result.Values {
+			values[i] = math.NaN()
+			if number, ok := chartValue(value); ok {
+				values[i] = number
+			}
+		}
+		(*p).chart.add(result.Time, values)
+		err = drawChart((*p).widget.(*linechart.LineChart), (*p).chart)
+	default:
+		err = (*p).widget.(*text.Text).Write(resultStreamText(result) + "\n")
+	}
+
+	return
+}
+
+// Charts a result of a keyed query, with a series for each key. Series take the first value of each
+// row other than its key.
+func (p *dashboardPanel) addKeyed(result storage.Result) (err error) {
+	var (
+		added bool // Whether any series are new.
+
+		index  = 0                                             // Index of the charted value.
+		values = make(map[int]float64, len((*p).chart.labels)) // Values by series index.
+	)
+
+	if (*p).key == 0 {
+		index = 1
+	}
+	// Keys missing from a result no longer have values.
+	for i := range (*p).chart.labels {
+		values[i] = math.NaN()
+	}
+	for _, row := range result.AllRows() {
+		if (*p).key >= len(row) || index >= len(row) {
+			continue
+		}
+		series, isNew := (*p).chart.series(fmt.Sprint(row[(*p).key]))
+		values[series], added = math.NaN(), added || isNew
+		if number, ok := chartValue(row[index]); ok {
+			values[series] = number
+		}
+	}
+	(*p).chart.add(result.Time, values)
+
+	if added {
+		if err = writeChartLegend((*p).legend, (*p).chart.labels); err != nil {
+			return
+		}
+	}
+
+	return drawChart((*p).widget.(*linechart.LineChart), (*p).chart)
+}
+
+// Container options for placing the panel, with any legend beneath it.
+func (p *dashboardPanel) layout() []container.Option {
+	options := []container.Option{
+		container.Border(linestyle.Light),
+		container.BorderTitle((*p).query),
+		container.BorderTitleAlignCenter(),
+	}
+	if (*p).legend != nil {
+		return append(options, container.SplitHorizontal(
+			container.Top(container.PlaceWidget((*p).widget)),
+			container.Bottom(container.PlaceWidget((*p).legend)),
+			container.SplitOption(container.SplitFixedFromEnd(1)),
+		))
+	}
+
+	return append(options, container.PlaceWidget((*p).widget))
+}
+
+// Determines the display mode of a query's panel from its configuration.
+func panelDisplayMode(query string) (displayMode DisplayMode) {
+	var (
+		err error // General error holder.
+	)
+
+	if config.QueryConfigs[query].Display == "" {
+		return DEFAULT_PANEL_DISPLAY
+	}
+	displayMode, err = DisplayModeFromString(config.QueryConfigs[query].Display)
+	if err != nil || !slices.Contains(panelDisplayModes, displayMode) {
+		slog.Warn(
+			"Unusable panel display mode",
+			"query", query,
+			"display", config.QueryConfigs[query].Display,
+		)
+		return DEFAULT_PANEL_DISPLAY
+	}
+
+	return
+}
+
+// Lays out containers evenly, either side by side or stacked.
+func splitEvenly(items [][]container.Option, sideBySide bool) []container.Option {
+	if len(items) == 1 {
+		return items[0]
+	}
+
+	rest := splitEvenly(items[1:], sideBySide)
+	if sideBySide {
+		return []container.Option{container.SplitVertical(
+			container.Left(items[0]...),
+			container.Right(rest...),
+			container.SplitPercent(100/len(items)),
+		)}
+	}
+
+	return []container.Option{container.SplitHorizontal(
+		container.Top(items[0]...),
+		container.Bottom(rest...),
+		container.SplitPercent(100/len(items)),
+	)}
+}
+
+// Lays out panels in a grid, filling rows up to a number of columns.
+func dashboardLayout(panels []*dashboardPanel, columns int) []container.Option {
+	var (
+		rows [][]container.Option // Layouts of each row.
+	)
+
+	for start := 0; start < len(panels); start += columns {
+		var row [][]container.Option // Layouts of panels in this row.
+		for _, panel := range panels[start:min(start+columns, len(panels))] {
+			row = append(row, panel.layout())
+		}
+		rows = append(rows, splitEvenly(row, true))
+	}
+
+	return splitEvenly(rows, false)
+}
+
+// Presents all queries at once, each in a panel of its own.
+func DashboardDisplay(
+	query string,
+	queries, filters, expressions []string,
+	displayConfig *DisplayConfig,
+) {
+	var (
+		err     error           // General error holder.
+		labels  []string        // Labels of presented values.
+		widgets termdashWidgets // Widgets for displaying.
+
+		columns = displayConfig.DashboardColumns        // Panels in each row.
+		panels  = make([]*dashboardPanel, len(queries)) // Panels, corresponding by index to queries.
+	)
+
+	// Wait for the first result to appear to synchronize storage.
+	GetResultWait(query)
+	readerIndexes[query].Dec()
+
+	// Initialize panels.
+	for i, panelQuery := range queries {
+		if len(expressions) > 0 {
+			// Expressions provide single-value results--apply a generic label.
+			labels = []string{"results"}
+		} else {
+			labels = store.GetLabels(panelQuery, filters)
+		}
+
+		panels[i], err = newDashboardPanel(
+			panelQuery, panelDisplayMode(panelQuery), labels, displayConfig)
+		e(err)
+	}
+	if columns <= 0 {
+		columns = DEFAULT_DASHBOARD_COLUMNS
+	}
+	widgets.resultsLayout = dashboardLayout(panels, columns)
+	widgets.statusWidget, err = text.New()
+	e(err)
+
+	// Start the display.
+	display(
+		DISPLAY_TERMDASH,
+		func() {
+			var (
+				prevResults = make(map[string]storage.Result) // Previous results of each query.
+			)
+
+			// Presents a filtered result in a panel.
+			present := func(panel *dashboardPanel, result storage.Result) {
+				if (*panel).query == query {
+					updateDisplayTermdashStatus(&widgets, result)
+				}
+				if result.IsEmptyValues() {
+					// Ignore empty results.
+					slog.Warn("Cannot display an empty result", "query", (*panel).query)
+					return
+				}
+
+				if len(expressions) > 0 {
+					result = ExprResult(
+						(*panel).query, expressions, result, prevResults[(*panel).query])
+					if len(result.Labels) > 0 {
+						// Values from expressions may have labels of their own.
+						(*panel).labels = result.Labels
+					}
+				}
+				e(panel.add(result))
+				prevResults[(*panel).query] = result
+			}
+
+			// Load existing results.
+			for _, panel := range panels {
+				store.EachToIndex(
+					(*panel).query,
+					filters,
+					readerIndexes[(*panel).query],
+					func(result storage.Result) bool {
+						present(panel, result)
+						return true
+					},
+				)
+			}
+
+			// Load new results.
+			for {
+				// Listen for an interrupt to stop result consumption for some display change.
+				select {
+				case <-interruptChan:
+					// We've received an interrupt.
+					return
+				case <-pauseDisplayChan:
+					// We've received a pause and need to wait for an unpause.
+					<-pauseDisplayChan
+				default:
+					received := false // Whether any query had a new result.
+
+					for _, panel := range panels {
+						nextResult := store.NextOrEmpty(
+							subscriptions[(*panel).query], readerIndexes[(*panel).query])
+						if nextResult.IsEmpty() {
+							continue
+						}
+						received = true
+
+						present(panel, store.FilterResult((*panel).query, filters, nextResult))
+					}
+
+					if !received {
+						waitForResults()
+					}
+				}
+			}
+		},
+	)
+
+	// Initialize the display. This must happen after the display function is invoked, otherwise data
+	// will never appear.
+	initDisplayTermdash(widgets, query, filters, store.GetLabels(query, filters), displayConfig)
+}
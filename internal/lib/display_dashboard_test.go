@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestPanelDisplayMode(t *testing.T) {
+	config = Config{QueryConfigs: map[string]QueryConfig{
+		"foo":  {Command: "foo", Display: "chart"},
+		"bar":  {Command: "bar", Display: "dashboard"},
+		"fizz": {Command: "fizz", Display: "buzz"},
+	}}
+	defer func() { config = Config{} }()
+
+	// It uses display modes configured for queries.
+	if got := panelDisplayMode("foo"); got != DISPLAY_MODE_CHART {
+		t.Errorf("Got: %v Expected: %v\n", got, DISPLAY_MODE_CHART)
+	}
+
+	// It uses a default for queries without a usable display mode.
+	for _, query := range []string{"bar", "fizz", "buzz"} {
+		if got := panelDisplayMode(query); got != DEFAULT_PANEL_DISPLAY {
+			t.Errorf("Got: %v Expected: %v\n", got, DEFAULT_PANEL_DISPLAY)
+		}
+	}
+}
+
+func TestDashboardPanel(t *testing.T) {
+	// It charts each value of a result as a series.
+	panel, err := newDashboardPanel(
+		"foo", DISPLAY_MODE_CHART, []string{"fizz", "buzz"}, NewDisplayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := storage.Result{Time: time.Now(), Values: storage.Values{int64(1), "bar"}}
+	if err = panel.add(result); err != nil {
+		t.Fatal(err)
+	}
+	if got := panel.chart.values[0][0]; got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+	if got := panel.chart.values[1][0]; !math.IsNaN(got) {
+		t.Errorf("Got: %v Expected: %v\n", got, math.NaN())
+	}
+
+	// It lays out panels for each row and column.
+	panels := []*dashboardPanel{panel, panel, panel}
+	if got := len(dashboardLayout(panels, 2)); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+}
+
+func TestDashboardPanelKeyed(t *testing.T) {
+	config = Config{QueryConfigs: map[string]QueryConfig{"foo": {Command: "foo", Key: "disk"}}}
+	defer func() { config = Config{} }()
+
+	// It charts a series for each key, with no values for keys that are missing.
+	panel, err := newDashboardPanel(
+		"foo", DISPLAY_MODE_CHART, []string{"disk", "used"}, NewDisplayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []storage.Result{
+		{Time: time.Now(), Rows: []storage.Values{{"sda", int64(1)}, {"sdb", int64(2)}}},
+		{Time: time.Now(), Values: storage.Values{"sdb", int64(3)}},
+	}
+	for _, result := range results {
+		if err = panel.add(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := panel.chart.labels; len(got) != 2 || got[0] != "sda" || got[1] != "sdb" {
+		t.Errorf("Got: %v Expected: %v\n", got, []string{"sda", "sdb"})
+	}
+	if got := panel.chart.values[0]; got[0] != 1 || !math.IsNaN(got[1]) {
+		t.Errorf("Got: %v\n", got)
+	}
+	if got := panel.chart.values[1]; got[0] != 2 || got[1] != 3 {
+		t.Errorf("Got: %v\n", got)
+	}
+}
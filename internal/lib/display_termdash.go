@@ -9,6 +9,7 @@ import (
 	"log/slog"
 
 	"github.com/mum4k/termdash"
+	"github.com/mum4k/termdash/cell"
 	"github.com/mum4k/termdash/container"
 	"github.com/mum4k/termdash/keyboard"
 	"github.com/mum4k/termdash/linestyle"
@@ -17,6 +18,8 @@ import (
 	"github.com/mum4k/termdash/widgetapi"
 	"github.com/mum4k/termdash/widgets/text"
 	slogmulti "github.com/samber/slog-multi"
+
+	"github.com/spacez320/shui/pkg/storage"
 )
 
 // Used to provide an io.Writer implementation of termdash text widgets.
@@ -32,8 +35,10 @@ func (t *termdashTextWriter) Write(p []byte) (n int, err error) {
 
 // Used to supply optional widgets to Termdash initialization.
 type termdashWidgets struct {
-	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget *text.Text
-	resultsWidget                                                  widgetapi.Widget
+	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget, statusWidget *text.Text
+	legendWidget                                                                 *text.Text
+	resultsWidget                                                                widgetapi.Widget
+	resultsLayout                                                                []container.Option
 }
 
 var (
@@ -73,6 +78,26 @@ func keyboardTermdashHandler(key *terminalapi.Keyboard) {
 
 		pauseDisplayChan <- true
 		pauseQueryChans[currentCtx.Value("query").(string)] <- true
+	case 'd':
+		// 'd' detaches from a Shui being read from, leaving it running.
+		if client == nil {
+			break
+		}
+		slog.Debug("Detaching")
+
+		currentCtx = context.WithValue(currentCtx, "detach", true)
+		cancel()
+		appTermdash.Close()
+	case 'S':
+		// 'S' stops a Shui being read from, along with this one.
+		if client == nil {
+			break
+		}
+		slog.Debug("Stopping remote Shui")
+
+		currentCtx = context.WithValue(currentCtx, "stop", true)
+		cancel()
+		appTermdash.Close()
 	}
 }
 
@@ -85,6 +110,20 @@ func errorTermdashHandler(e error) {
 	slog.Error(e.Error())
 }
 
+// Updates the status widget to reflect the latest result.
+func updateDisplayTermdashStatus(widgets *termdashWidgets, result storage.Result) {
+	widgets.statusWidget.Reset()
+	if alerts := alertStatusText(); alerts != "" {
+		// Firing alerts come first and stand out from the status of the result.
+		widgets.statusWidget.Write(alerts, text.WriteCellOpts(cell.FgColor(cell.ColorRed), cell.Bold()))
+		widgets.statusWidget.Write(" | ")
+	}
+	widgets.statusWidget.Write(resultStatusText(result))
+	if delivery := deliveryStatusText(); delivery != "" {
+		widgets.statusWidget.Write(" | " + delivery)
+	}
+}
+
 // Sets-up the termdash container, which defines the overall layout, and begins running the display.
 // func initDisplayTermdash(resultsWidget, helpWidget, logsWidget widgetapi.Widget) {
 func initDisplayTermdash(
@@ -99,6 +138,7 @@ func initDisplayTermdash(
 		logsWidgetWriter  termdashTextWriter   // Writer implementation for logs.
 		logsWidgetHandler slog.Handler         // Log handler for Termdash apps.
 		mainWidgets       []container.Option   // Status and result widgets.
+		resultsWidgets    []container.Option   // Result widgets.
 		widgetContainer   *container.Container // Wrapper for widgets.
 	)
 	widgets.filterWidget, err = text.New()
@@ -112,7 +152,7 @@ func initDisplayTermdash(
 	if displayConfig.ShowHelp {
 		widgets.helpWidget, err = text.New()
 		e(err)
-		widgets.helpWidget.Write(HELP_TEXT)
+		widgets.helpWidget.Write(helpText())
 	}
 	if displayConfig.ShowLogs {
 		widgets.logsWidget, err = text.New(text.RollContent())
@@ -126,6 +166,24 @@ func initDisplayTermdash(
 	appTermdash, err = tcell.New()
 	e(err)
 
+	// Set-up the results, with any legend beneath them.
+	resultsWidgets = []container.Option{
+		container.Border(linestyle.Light),
+		container.BorderTitle("Results"),
+		container.BorderTitleAlignCenter(),
+	}
+	if len(widgets.resultsLayout) > 0 {
+		resultsWidgets = append(resultsWidgets, widgets.resultsLayout...)
+	} else if widgets.legendWidget != nil {
+		resultsWidgets = append(resultsWidgets, container.SplitHorizontal(
+			container.Top(container.PlaceWidget(widgets.resultsWidget)),
+			container.Bottom(container.PlaceWidget(widgets.legendWidget)),
+			container.SplitOption(container.SplitFixedFromEnd(1)),
+		))
+	} else {
+		resultsWidgets = append(resultsWidgets, container.PlaceWidget(widgets.resultsWidget))
+	}
+
 	// Set-up the status widgets with results.
 	if displayConfig.ShowStatus {
 		mainWidgets = []container.Option{
@@ -147,32 +205,32 @@ func initDisplayTermdash(
 									container.PlaceWidget(widgets.labelWidget),
 								),
 								container.Right(
-									container.Border(linestyle.Light),
-									container.BorderTitle("Filters"),
-									container.BorderTitleAlignCenter(),
-									container.PlaceWidget(widgets.labelWidget),
+									container.SplitVertical(
+										container.Left(
+											container.Border(linestyle.Light),
+											container.BorderTitle("Filters"),
+											container.BorderTitleAlignCenter(),
+											container.PlaceWidget(widgets.filterWidget),
+										),
+										container.Right(
+											container.Border(linestyle.Light),
+											container.BorderTitle("Status"),
+											container.BorderTitleAlignCenter(),
+											container.PlaceWidget(widgets.statusWidget),
+										),
+									),
 								),
 							),
 						),
 						container.SplitPercent(33),
 					),
 				),
-				container.Bottom(
-					container.Border(linestyle.Light),
-					container.BorderTitle("Results"),
-					container.BorderTitleAlignCenter(),
-					container.PlaceWidget(widgets.resultsWidget),
-				),
+				container.Bottom(resultsWidgets...),
 				container.SplitOption(container.SplitFixed(3)),
 			),
 		}
 	} else {
-		mainWidgets = []container.Option{
-			container.Border(linestyle.Light),
-			container.BorderTitle("Results"),
-			container.BorderTitleAlignCenter(),
-			container.PlaceWidget(widgets.resultsWidget),
-		}
+		mainWidgets = resultsWidgets
 	}
 
 	if widgets.helpWidget != nil && widgets.logsWidget != nil {
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestChartData(t *testing.T) {
+	start := time.Date(2024, time.June, 10, 17, 40, 29, 0, time.UTC)
+	data := newChartData([]string{"foo", "bar"}, 3)
+
+	// It carries values forward for series without new values.
+	data.add(start, map[int]float64{0: 1})
+	data.add(start.Add(time.Second), map[int]float64{1: 2.5})
+	data.add(start.Add(2*time.Second), map[int]float64{0: 3, 1: math.NaN()})
+	if got := data.values[0]; !reflect.DeepEqual(got, []float64{1, 1, 3}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []float64{1, 1, 3})
+	}
+
+	// It has no values for series before their first value, or for values that aren't numbers.
+	if got := data.values[1]; !math.IsNaN(got[0]) || got[1] != 2.5 || !math.IsNaN(got[2]) {
+		t.Errorf("Got: %v\n", got)
+	}
+
+	// It keeps a limited number of points.
+	data.add(start.Add(3*time.Second), map[int]float64{0: 4})
+	if got := data.values[0]; !reflect.DeepEqual(got, []float64{1, 3, 4}) {
+		t.Errorf("Got: %v Expected: %v\n", got, []float64{1, 3, 4})
+	}
+
+	// It labels points with their times.
+	expected := map[int]string{0: "17:40:30", 1: "17:40:31", 2: "17:40:32"}
+	if got := data.xLabels(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+func TestChartDataSeries(t *testing.T) {
+	data := newChartData([]string{"foo"}, 3)
+	data.add(time.Now(), map[int]float64{0: 1})
+
+	// It finds existing series.
+	if index, added := data.series("foo"); index != 0 || added {
+		t.Errorf("Got: %v %v Expected: %v %v\n", index, added, 0, false)
+	}
+
+	// It adds series without values for existing points.
+	index, added := data.series("bar")
+	if index != 1 || !added || len(data.values[1]) != 1 || !math.IsNaN(data.values[1][0]) {
+		t.Errorf("Got: %v %v %v\n", index, added, data.values)
+	}
+}
+
+func TestChartValue(t *testing.T) {
+	// It converts numbers, keeping precision.
+	for _, value := range []interface{}{int64(2), 2.5, " 2.5 "} {
+		if _, ok := chartValue(value); !ok {
+			t.Errorf("Got: %v Expected: %v\n", ok, true)
+		}
+	}
+	if got, _ := chartValue("0.125"); got != 0.125 {
+		t.Errorf("Got: %v Expected: %v\n", got, 0.125)
+	}
+
+	// It rejects values that aren't numbers.
+	if _, ok := chartValue("foo"); ok {
+		t.Errorf("Got: %v Expected: %v\n", ok, false)
+	}
+}
+
+func TestDiffHistory(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutRetention(map[string]storage.RetentionPolicy{"foo": {MaxCount: 3}})
+	start := time.Date(2024, time.June, 10, 17, 40, 29, 0, time.UTC)
+	put := func(i int) {
+		store.PutResult("foo", false, storage.Result{
+			Time: start.Add(time.Duration(i) * time.Second), Values: storage.Values{int64(i)}})
+	}
+	for i := 0; i < 3; i++ {
+		put(i)
+	}
+	history := newDiffHistory("foo")
+
+	// It steps back, comparing against the result before.
+	history.move(HISTORY_BACK)
+	from, to, ok := history.results()
+	if !ok || from.Values.Get(0) != int64(0) || to.Values.Get(0) != int64(1) || history.following {
+		t.Errorf("Got: %v %v Expected: %v %v\n", from.Values, to.Values, 0, 1)
+	}
+
+	// It keeps comparing the same results while retention trims the series.
+	history.move(HISTORY_MARK)
+	history.move(HISTORY_FORWARD)
+	put(3)
+	from, to, ok = history.results()
+	if !ok || from.Values.Get(0) != int64(1) || to.Values.Get(0) != int64(2) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", from.Values, to.Values, 1, 2)
+	}
+
+	// It clears a mark once retention removes the marked result.
+	put(4)
+	from, to, ok = history.results()
+	if !ok || !from.IsEmpty() || to.Values.Get(0) != int64(2) || !history.mark.IsZero() {
+		t.Errorf("Got: %v %v Expected: %v %v\n", from.Values, to.Values, nil, 2)
+	}
+
+	// It moves past results removed by retention.
+	put(5)
+	from, to, ok = history.results()
+	if !ok || !from.IsEmpty() || to.Values.Get(0) != int64(3) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", from.Values, to.Values, nil, 3)
+	}
+
+	// It follows the latest result.
+	history.move(HISTORY_FOLLOW)
+	if _, to, _ = history.results(); to.Values.Get(0) != int64(5) || !history.following {
+		t.Errorf("Got: %v Expected: %v\n", to.Values, 5)
+	}
+}
@@ -11,13 +11,15 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	slogmulti "github.com/samber/slog-multi"
+
+	"github.com/spacez320/shui/pkg/storage"
 )
 
 // Widgets for tview displays.
 type tviewWidgets struct {
-	flexBox                                                        *tview.Flex
-	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget *tview.TextView
-	resultsWidget                                                  tview.Primitive
+	flexBox                                                                      *tview.Flex
+	filterWidget, helpWidget, labelWidget, logsWidget, queryWidget, statusWidget *tview.TextView
+	resultsWidget                                                                tview.Primitive
 }
 
 var (
@@ -56,6 +58,30 @@ func keyboardTviewHandler(event *tcell.EventKey) *tcell.EventKey {
 				pauseDisplayChan <- true
 				pauseQueryChans[currentCtx.Value("query").(string)] <- true
 			}()
+		case '[', ']', 'm', 'f':
+			// Moving through history is only possible in some displays, which may not be listening.
+			select {
+			case historyChan <- historyActionKeys[event.Rune()]:
+			default:
+			}
+		case 'd':
+			// 'd' detaches from a Shui being read from, leaving it running.
+			if client == nil {
+				break
+			}
+			slog.Debug("Detaching")
+
+			currentCtx = context.WithValue(currentCtx, "detach", true)
+			appTview.Stop()
+		case 'S':
+			// 'S' stops a Shui being read from, along with this one.
+			if client == nil {
+				break
+			}
+			slog.Debug("Stopping remote Shui")
+
+			currentCtx = context.WithValue(currentCtx, "stop", true)
+			appTview.Stop()
 		}
 	case tcell.KeyTab:
 		// Tab switches display modes.
@@ -104,6 +130,32 @@ func initDisplayTviewText(
 	return
 }
 
+// Display init function specific to diff results.
+func initDisplayTviewDiff(
+	query string,
+	filters, labels []string,
+	displayConfig *DisplayConfig,
+) (widgets tviewWidgets) {
+	widgets = initDisplayTviewText(query, filters, labels, displayConfig)
+	widgets.resultsWidget.(*tview.TextView).SetDynamicColors(true)
+	widgets.helpWidget.SetText(helpText() + HELP_TEXT_HISTORY)
+
+	return
+}
+
+// Updates the status widget to reflect the latest result.
+func updateDisplayTviewStatus(widgets *tviewWidgets, result storage.Result) {
+	status := tview.Escape(resultStatusText(result))
+	if delivery := deliveryStatusText(); delivery != "" {
+		status += " | " + tview.Escape(delivery)
+	}
+	if alerts := alertStatusText(); alerts != "" {
+		// Firing alerts come first and stand out from the status of the result.
+		status = "[red::b]" + tview.Escape(alerts) + "[-::-] | " + status
+	}
+	widgets.statusWidget.SetText(status)
+}
+
 // Sets-up the tview flex box, which defines the overall layout. Meant to encapsulate the common
 // things needed regardless of what from the results view takes (assuming it fits into flex box).
 //
@@ -127,11 +179,13 @@ func initDisplayTview(
 	widgets.labelWidget = tview.NewTextView()
 	widgets.logsWidget = tview.NewTextView()
 	widgets.queryWidget = tview.NewTextView()
+	widgets.statusWidget = tview.NewTextView()
 
 	statusWidgets = tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(widgets.queryWidget, 0, 1, false).
 		AddItem(widgets.labelWidget, 0, 1, false).
-		AddItem(widgets.filterWidget, 0, 1, false)
+		AddItem(widgets.filterWidget, 0, 1, false).
+		AddItem(widgets.statusWidget, 0, 1, false)
 
 	// Set-up the layout and apply views.
 	widgets.flexBox = widgets.flexBox.
@@ -151,7 +205,7 @@ func initDisplayTview(
 
 	// Initialize the help view.
 	widgets.helpWidget.SetBorder(true).SetTitle("Help")
-	fmt.Fprint(widgets.helpWidget, HELP_TEXT)
+	fmt.Fprint(widgets.helpWidget, helpText())
 
 	// Initialize the top-line status widgets.
 	widgets.filterWidget.SetBorder(true).SetTitle("Filters")
@@ -160,6 +214,8 @@ func initDisplayTview(
 	fmt.Fprintf(widgets.labelWidget, "%v", labels)
 	widgets.queryWidget.SetBorder(true).SetTitle("Query")
 	fmt.Fprintf(widgets.queryWidget, query)
+	widgets.statusWidget.SetDynamicColors(true).SetChangedFunc(func() { appTview.Draw() })
+	widgets.statusWidget.SetBorder(true).SetTitle("Status")
 
 	// Initialize the logs view.
 	widgets.logsWidget.SetScrollable(false).SetChangedFunc(func() { appTview.Draw() })
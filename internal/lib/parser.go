@@ -0,0 +1,459 @@
+//
+// Parsing of query output into values.
+//
+// By default, output is split on whitespace. Queries may instead choose a parser suited to their
+// output, which may also provide labels and produce more than one row of values.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+// Represents a parser of query output.
+type Parser int
+
+// Fetches a common name from a parser value.
+func (p Parser) String() string {
+	return Parsers[p]
+}
+
+// Parser constants.
+const (
+	PARSER_WHITESPACE Parser = iota // Splits output on whitespace. First to serve as the 'default.'
+	PARSER_JSON                     // Selects values from JSON output.
+	PARSER_CSV                      // Splits lines of output on commas.
+	PARSER_TSV                      // Splits lines of output on tabs.
+	PARSER_DELIMITED                // Splits lines of output on a configured delimiter.
+	PARSER_COLUMNS                  // Splits lines of output into aligned columns.
+	PARSER_REGEX                    // Captures values from output with a regular expression.
+)
+
+// Misc. constants.
+const (
+	JSON_PATH_SEPARATOR = "." // Separates keys and indexes in JSON paths.
+	JSON_PATH_WILDCARD  = "#" // Matches every element of an array in JSON paths.
+)
+
+var (
+	// Mapping of parser constants to a common parser name.
+	Parsers = map[Parser]string{
+		PARSER_WHITESPACE: "whitespace",
+		PARSER_JSON:       "json",
+		PARSER_CSV:        "csv",
+		PARSER_TSV:        "tsv",
+		PARSER_DELIMITED:  "delimited",
+		PARSER_COLUMNS:    "columns",
+		PARSER_REGEX:      "regex",
+	}
+
+	patterns      = make(map[string]*regexp.Regexp) // Compiled regex patterns, by pattern.
+	patternsMutex = &sync.RWMutex{}                 // Mutex for managing compiled patterns.
+)
+
+// Fetches a parser value from its common name. No name is the default parser.
+func ParserFromString(s string) (Parser, error) {
+	if s == "" {
+		return PARSER_WHITESPACE, nil
+	}
+	for k, v := range Parsers {
+		if s == v {
+			return k, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Unknown parser %s", s))
+}
+
+// Checks that a query's parser configuration is usable.
+func ValidateParser(queryConfig QueryConfig) (err error) {
+	var (
+		parser  Parser         // Parser to validate.
+		pattern *regexp.Regexp // Compiled regex parser pattern.
+	)
+
+	if parser, err = ParserFromString(queryConfig.Parser); err != nil {
+		return
+	}
+
+	switch parser {
+	case PARSER_DELIMITED:
+		_, err = parseDelimiter(queryConfig.Delimiter)
+	case PARSER_REGEX:
+		if pattern, err = regexPattern(queryConfig.Pattern); err != nil {
+			return
+		}
+		if len(regexLabels(pattern)) == 0 {
+			err = errors.New(fmt.Sprintf("Pattern has no named groups: %s", queryConfig.Pattern))
+		}
+	}
+
+	return
+}
+
+// Converts a decoded JSON value into a result value. Numbers are integers where possible, and
+// anything that isn't a number is a string.
+func jsonValue(value interface{}) interface{} {
+	switch value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value.(string)
+	case json.Number:
+		if intValue, err := value.(json.Number).Int64(); err == nil {
+			return intValue
+		}
+		floatValue, _ := value.(json.Number).Float64()
+		return floatValue
+	case bool:
+		return strconv.FormatBool(value.(bool))
+	default:
+		// Objects and arrays are kept as JSON.
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+// Finds the values at a path in decoded JSON. Wildcards in the path match every element of an
+// array, producing a value for each. Parts of the path that don't exist produce no values.
+func jsonPathValues(data interface{}, path []string) (values []interface{}) {
+	if len(path) == 0 {
+		return []interface{}{data}
+	}
+
+	switch data.(type) {
+	case map[string]interface{}:
+		if next, ok := data.(map[string]interface{})[path[0]]; ok {
+			values = jsonPathValues(next, path[1:])
+		}
+	case []interface{}:
+		elements := data.([]interface{})
+		if path[0] == JSON_PATH_WILDCARD {
+			for _, element := range elements {
+				values = append(values, jsonPathValues(element, path[1:])...)
+			}
+		} else if index, err := strconv.Atoi(path[0]); err == nil && index >= 0 {
+			if index < len(elements) {
+				values = jsonPathValues(elements[index], path[1:])
+			}
+		}
+	}
+
+	return
+}
+
+// Parses JSON output. Each path selects a labeled value. Paths with wildcards produce a row for
+// each value they match, while other paths repeat their value in every row. Without paths, the
+// keys of an object become labels, and an array of objects produces a row for each object.
+func parseJSON(output string, paths []string) (labels []string, rows []storage.Values, err error) {
+	var (
+		data    interface{}                                        // Decoded output.
+		decoder = json.NewDecoder(bytes.NewReader([]byte(output))) // Decoder that preserves numbers.
+	)
+
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return
+	}
+
+	// Without paths, use the keys of the top-level objects.
+	if len(paths) == 0 {
+		objects, isArray := data.([]interface{})
+		if !isArray {
+			objects = []interface{}{data}
+		}
+		for _, object := range objects {
+			if object, ok := object.(map[string]interface{}); ok {
+				for key := range object {
+					if !slices.Contains(paths, key) {
+						paths = append(paths, key)
+					}
+				}
+			}
+		}
+		if len(paths) == 0 {
+			// There are no objects, so the output is a single value.
+			return nil, []storage.Values{{jsonValue(data)}}, nil
+		}
+		sort.Strings(paths)
+		if isArray {
+			for i := range paths {
+				paths[i] = JSON_PATH_WILDCARD + JSON_PATH_SEPARATOR + paths[i]
+			}
+		}
+	}
+
+	// Find values for each path, counting rows along the way.
+	pathValues := make([][]interface{}, len(paths))
+	rowCount := 1
+	for i, path := range paths {
+		pathValues[i] = jsonPathValues(data, strings.Split(path, JSON_PATH_SEPARATOR))
+		if strings.Contains(path, JSON_PATH_WILDCARD) {
+			rowCount = max(rowCount, len(pathValues[i]))
+		}
+	}
+
+	rows = make([]storage.Values, rowCount)
+	for i := range rows {
+		rows[i] = make(storage.Values, len(paths))
+		for j, path := range paths {
+			switch {
+			case strings.Contains(path, JSON_PATH_WILDCARD) && i < len(pathValues[j]):
+				rows[i][j] = jsonValue(pathValues[j][i])
+			case !strings.Contains(path, JSON_PATH_WILDCARD) && len(pathValues[j]) > 0:
+				rows[i][j] = jsonValue(pathValues[j][0])
+			default:
+				rows[i][j] = ""
+			}
+		}
+	}
+	labels = paths
+
+	return
+}
+
+// Determines whether the first of some records is a header. Headers are expected to have no
+// numbers, while the records after them have some.
+func detectHeader(records [][]string) bool {
+	if len(records) < 2 {
+		return false
+	}
+	for _, field := range records[0] {
+		if _, isString := tokenValue(strings.TrimSpace(field)).(string); !isString {
+			return false
+		}
+	}
+	for _, record := range records[1:] {
+		for _, field := range record {
+			if _, isString := tokenValue(strings.TrimSpace(field)).(string); !isString {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Determines whether the first of some records is a header, as configured or otherwise detected.
+func hasHeader(records [][]string, header *bool) bool {
+	if header != nil {
+		return *header
+	}
+
+	return detectHeader(records)
+}
+
+// Converts records of tabular output into rows of values. A header, if present, becomes labels.
+func tabularRows(records [][]string, header bool) (labels []string, rows []storage.Values) {
+	if len(records) == 0 {
+		return
+	}
+	if header {
+		labels, records = records[0], records[1:]
+		for i := range labels {
+			labels[i] = strings.TrimSpace(labels[i])
+		}
+	}
+
+	rows = make([]storage.Values, len(records))
+	for i, record := range records {
+		rows[i] = make(storage.Values, len(record))
+		for j, field := range record {
+			rows[i][j] = tokenValue(strings.TrimSpace(field))
+		}
+	}
+
+	return
+}
+
+// Parses output with a line for each row and fields separated by a delimiter, quoted as CSV.
+func parseDelimited(
+	output string,
+	delimiter rune,
+	header *bool,
+) (labels []string, rows []storage.Values, err error) {
+	var (
+		records [][]string // Fields of each line.
+
+		reader = csv.NewReader(strings.NewReader(output)) // Reader for delimited output.
+	)
+
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	// Trimming space would also remove whitespace delimiters between empty fields.
+	reader.TrimLeadingSpace = !unicode.IsSpace(delimiter)
+	if records, err = reader.ReadAll(); err != nil {
+		return
+	}
+	labels, rows = tabularRows(records, hasHeader(records, header))
+
+	return
+}
+
+// Parses output aligned into columns, like that of `ps` or `df`. Fields are separated by
+// whitespace, and the last field of a line takes the remainder of it, so that it may contain
+// spaces. Headers with more words than lines have fields join their last words into one label.
+func parseColumns(output string, header *bool) (labels []string, rows []storage.Values) {
+	var (
+		records [][]string // Fields of each line.
+	)
+
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			records = append(records, fields)
+		}
+	}
+	if len(records) == 0 || !hasHeader(records, header) {
+		return tabularRows(records, false)
+	}
+
+	// Fit the header to the fewest fields of any line.
+	columns := len(records[0])
+	for _, record := range records[1:] {
+		columns = min(columns, len(record))
+	}
+	records[0] = append(records[0][:columns-1], strings.Join(records[0][columns-1:], " "))
+
+	// Fit lines to the header.
+	for i, record := range records[1:] {
+		if len(record) > columns {
+			records[i+1] = append(record[:columns-1], strings.Join(record[columns-1:], " "))
+		}
+	}
+
+	return tabularRows(records, true)
+}
+
+// Provides the names of the named groups of a pattern.
+func regexLabels(pattern *regexp.Regexp) (labels []string) {
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			labels = append(labels, name)
+		}
+	}
+
+	return
+}
+
+// Provides a compiled regex pattern, compiling it if it hasn't been already.
+func regexPattern(pattern string) (compiled *regexp.Regexp, err error) {
+	var (
+		ok bool // Whether the pattern was already compiled.
+	)
+
+	patternsMutex.RLock()
+	compiled, ok = patterns[pattern]
+	patternsMutex.RUnlock()
+	if ok {
+		return
+	}
+
+	if compiled, err = regexp.Compile(pattern); err != nil {
+		return
+	}
+	patternsMutex.Lock()
+	patterns[pattern] = compiled
+	patternsMutex.Unlock()
+
+	return
+}
+
+// Parses output with a regular expression. Named groups become labels, and each match of the
+// pattern produces a row of captured values.
+func parseRegex(output, pattern string) (labels []string, rows []storage.Values, err error) {
+	var (
+		compiled *regexp.Regexp // Compiled pattern.
+	)
+
+	if compiled, err = regexPattern(pattern); err != nil {
+		return
+	}
+	labels = regexLabels(compiled)
+
+	for _, match := range compiled.FindAllStringSubmatch(output, -1) {
+		row := make(storage.Values, 0, len(labels))
+		for i, name := range compiled.SubexpNames() {
+			if name != "" {
+				row = append(row, tokenValue(strings.TrimSpace(match[i])))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return
+}
+
+// Reads a delimiter, which must be a single character.
+func parseDelimiter(delimiter string) (rune, error) {
+	if r, size := utf8.DecodeRuneInString(delimiter); size > 0 && size == len(delimiter) {
+		return r, nil
+	}
+
+	return 0, errors.New(fmt.Sprintf("Delimiter must be a single character: %q", delimiter))
+}
+
+// Parses query output according to a query's configuration. Labels are only provided by parsers
+// that can determine them.
+func parseResult(
+	queryConfig QueryConfig,
+	output string,
+) (labels []string, rows []storage.Values, err error) {
+	var (
+		parser Parser // Parser to use.
+	)
+
+	if parser, err = ParserFromString(queryConfig.Parser); err != nil {
+		return
+	}
+
+	switch parser {
+	case PARSER_JSON:
+		// Labels read better without leading wildcards.
+		labels, rows, err = parseJSON(output, slices.Clone(queryConfig.Paths))
+		for i := range labels {
+			labels[i] = strings.TrimPrefix(labels[i], JSON_PATH_WILDCARD+JSON_PATH_SEPARATOR)
+		}
+	case PARSER_CSV:
+		labels, rows, err = parseDelimited(output, ',', queryConfig.Header)
+	case PARSER_TSV:
+		labels, rows, err = parseDelimited(output, '\t', queryConfig.Header)
+	case PARSER_DELIMITED:
+		var delimiter rune // Separator of fields.
+		if delimiter, err = parseDelimiter(queryConfig.Delimiter); err != nil {
+			return
+		}
+		labels, rows, err = parseDelimited(output, delimiter, queryConfig.Header)
+	case PARSER_COLUMNS:
+		labels, rows = parseColumns(output, queryConfig.Header)
+	case PARSER_REGEX:
+		labels, rows, err = parseRegex(output, queryConfig.Pattern)
+	default:
+		if queryConfig.Key == "" {
+			rows = []storage.Values{TokenizeResult(output)}
+			break
+		}
+		// Keyed results have a row for each line.
+		for _, line := range strings.Split(output, "\n") {
+			if values := TokenizeResult(line); len(values) > 0 {
+				rows = append(rows, values)
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestParseJSON(t *testing.T) {
+	output := `{"kind": "List", "count": 2, "items": [
+		{"name": "foo", "ready": true, "usage": {"cpu": 0.5}},
+		{"name": "bar", "ready": false, "usage": {"cpu": 2}}
+	]}`
+
+	// It selects labeled values by path, with a row for each array element.
+	labels, rows, err := parseJSON(output, []string{"kind", "items.#.name", "items.#.usage.cpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := []string{"kind", "items.#.name", "items.#.usage.cpu"}
+	expectedRows := []storage.Values{{"List", "foo", 0.5}, {"List", "bar", int64(2)}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// It selects array elements by index, and has empty values for missing paths.
+	_, rows, _ = parseJSON(output, []string{"items.1.name", "missing"})
+	expectedRows = []storage.Values{{"bar", ""}}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expectedRows)
+	}
+
+	// Without paths, it uses the keys of objects in an array.
+	labels, rows, _ = parseJSON(`[{"b": 1, "a": "x"}, {"a": "y"}]`, []string{})
+	expectedLabels = []string{"#.a", "#.b"}
+	expectedRows = []storage.Values{{"x", int64(1)}, {"y", ""}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// It fails on output that isn't JSON.
+	if _, _, err = parseJSON("foo", []string{}); err == nil {
+		t.Errorf("Got: %v Expected: an error\n", err)
+	}
+}
+
+func TestParseDelimited(t *testing.T) {
+	// It detects a header, using it for labels, with a row for each line.
+	labels, rows, err := parseDelimited("name,size\nfoo, 1\n\"b,ar\",2.5\n", ',', nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := []string{"name", "size"}
+	expectedRows := []storage.Values{{"foo", int64(1)}, {"b,ar", 2.5}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// It keeps empty fields between whitespace delimiters.
+	_, rows, _ = parseDelimited("a\t\tb", '\t', nil)
+	expectedRows = []storage.Values{{"a", "", "b"}}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expectedRows)
+	}
+
+	// It doesn't detect a header without numbers after it, unless told there is one.
+	labels, _, _ = parseDelimited("a|b\nc|d", '|', nil)
+	if labels != nil {
+		t.Errorf("Got: %v Expected: %v\n", labels, nil)
+	}
+	header := true
+	labels, rows, _ = parseDelimited("a|b\nc|d", '|', &header)
+	expectedLabels, expectedRows = []string{"a", "b"}, []storage.Values{{"c", "d"}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	// It joins header words beyond the columns of lines.
+	labels, rows := parseColumns(
+		"Filesystem  Size  Mounted on\n/dev/sda1   100   /\ntmpfs       2.5   /tmp\n", nil)
+	expectedLabels := []string{"Filesystem", "Size", "Mounted on"}
+	expectedRows := []storage.Values{{"/dev/sda1", int64(100), "/"}, {"tmpfs", 2.5, "/tmp"}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// It puts the remainder of lines in the last column.
+	labels, rows = parseColumns("PID CMD\n1 init --foo bar\n22 sh", nil)
+	expectedLabels = []string{"PID", "CMD"}
+	expectedRows = []storage.Values{{int64(1), "init --foo bar"}, {int64(22), "sh"}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// Without a header, it splits lines on whitespace.
+	labels, rows = parseColumns("1 2\n3 4 5", nil)
+	expectedRows = []storage.Values{{int64(1), int64(2)}, {int64(3), int64(4), int64(5)}}
+	if labels != nil || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v\n", labels, rows, expectedRows)
+	}
+}
+
+func TestParseRegex(t *testing.T) {
+	output := " 10:00:00 up 2 days,  load average: 0.52, 0.58, 0.59"
+
+	// It labels values with named groups, typing captured values.
+	labels, rows, err := parseRegex(output, `up (?P<days>\d+) (day|days).*: (?P<load1>[\d.]+),`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := []string{"days", "load1"}
+	expectedRows := []storage.Values{{int64(2), 0.52}}
+	if !reflect.DeepEqual(labels, expectedLabels) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v %v Expected: %v %v\n", labels, rows, expectedLabels, expectedRows)
+	}
+
+	// It produces a row for each match.
+	_, rows, _ = parseRegex("a=1 b=2", `(?P<key>\w)=(?P<value>\d)`)
+	expectedRows = []storage.Values{{"a", int64(1)}, {"b", int64(2)}}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expectedRows)
+	}
+
+	// It compiles patterns once, reusing them for later output.
+	if _, ok := patterns[`(?P<key>\w)=(?P<value>\d)`]; !ok {
+		t.Errorf("Got: %v Expected: %v\n", ok, true)
+	}
+}
+
+func TestValidateParser(t *testing.T) {
+	// It accepts usable configuration.
+	for _, queryConfig := range []QueryConfig{
+		{},
+		{Parser: "delimited", Delimiter: ";"},
+		{Parser: "regex", Pattern: `(?P<a>\d+)`},
+	} {
+		if err := ValidateParser(queryConfig); err != nil {
+			t.Errorf("Got: %v Expected: %v\n", err, nil)
+		}
+	}
+
+	// It rejects unusable configuration.
+	for _, queryConfig := range []QueryConfig{
+		{Parser: "foo"},
+		{Parser: "delimited"},
+		{Parser: "regex", Pattern: `(\d+`},
+		{Parser: "regex", Pattern: `(\d+)`},
+	} {
+		if err := ValidateParser(queryConfig); err == nil {
+			t.Errorf("Got: %v Expected: an error\n", err)
+		}
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	// It splits output on whitespace by default.
+	labels, rows, _ := parseResult(QueryConfig{}, "1 2.5 foo")
+	expected := []storage.Values{{int64(1), 2.5, "foo"}}
+	if labels != nil || !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Got: %v %v Expected: %v\n", labels, rows, expected)
+	}
+
+	// It splits output into a row for each line for keyed queries.
+	_, rows, _ = parseResult(QueryConfig{Key: "0"}, "sda 1\n\nsdb 2\n")
+	expected = []storage.Values{{"sda", int64(1)}, {"sdb", int64(2)}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Got: %v Expected: %v\n", rows, expected)
+	}
+
+	// It shortens labels from JSON paths with leading wildcards.
+	labels, _, _ = parseResult(QueryConfig{Parser: "json"}, `[{"a": 1}]`)
+	if expected := []string{"a"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", labels, expected)
+	}
+
+	// It requires a single character delimiter.
+	if _, _, err := parseResult(QueryConfig{Parser: "delimited", Delimiter: "::"}, ""); err == nil {
+		t.Errorf("Got: %v Expected: an error\n", err)
+	}
+
+	// It fails with unknown parsers.
+	if _, _, err := parseResult(QueryConfig{Parser: "foo"}, ""); err == nil {
+		t.Errorf("Got: %v Expected: an error\n", err)
+	}
+}
@@ -5,13 +5,18 @@ package lib
 
 import (
 	"bufio"
-	"fmt"
-	"io"
+	"bytes"
+	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
 )
 
 const (
@@ -20,17 +25,43 @@ const (
 	QUERY_MODE_STDIN                  // Results are fron stdin.
 )
 
+const (
+	// Time to wait for command output to close after a command has been killed, in case processes
+	// outside of the command's process group are holding onto it.
+	QUERY_WAIT_DELAY = time.Second
+)
+
 var (
 	stdinScanner = bufio.NewScanner(os.Stdin) // Scanner for standard input queries.
 )
 
+// Builds a context for a single query execution, applying a timeout (in seconds) if it is greater
+// than zero.
+func queryContext(timeout int) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// Determines the timeout to use for a query, preferring query specific configuration over the
+// global timeout.
+func queryTimeout(query string, timeout int, queryConfigs map[string]QueryConfig) int {
+	if queryConfig, ok := queryConfigs[query]; ok && queryConfig.Timeout > 0 {
+		return queryConfig.Timeout
+	}
+
+	return timeout
+}
+
 // Wrapper for query execution.
 func runQuery(
 	query string,
-	attempts, delay int,
+	attempts, delay, timeout int,
 	history bool,
 	doneChan, pauseChan chan bool,
-	queryFunc func(string, bool) bool,
+	queryFunc func(context.Context, string, bool) bool,
 ) {
 	// This loop executes as long as attempts has not been reached, or indefinitely if attempts is
 	// less than zero.
@@ -41,11 +72,14 @@ func runQuery(
 			// pause channel.
 			<-pauseChan
 		default:
-			if !queryFunc(query, history) {
+			// Each execution is bound by its own timeout.
+			queryCtx, queryCancel := queryContext(timeout)
+			if !queryFunc(queryCtx, query, history) {
 				// In the event that queryFunc returns false, allow this to signal query completion, even if
 				// attempts are not satisifed.
 				attempts = 0
 			}
+			queryCancel()
 
 			// This is not the last execution--add a delay.
 			if i != attempts {
@@ -59,45 +93,59 @@ func runQuery(
 }
 
 // Executes a query as a command to exec.
-func runQueryExec(query string, history bool) bool {
-	slog.Debug("Executing query", "query", query)
+func runQueryExec(ctx context.Context, query string, history bool) bool {
+	var (
+		stderr, stdout bytes.Buffer // Command output.
+	)
 
-	// Prepare query execution.
-	cmd := exec.Command("bash", "-c", query)
+	slog.Debug("Executing query", "query", query)
 
-	// Set-up pipes for command output.
-	stdout, stdout_err := cmd.StdoutPipe()
-	stderr, stderr_err := cmd.StderrPipe()
-	e(stdout_err)
-	e(stderr_err)
+	// Prepare query execution. Commands are run in their own process group so that cancellation
+	// also reaches anything the shell has spawned.
+	cmd := exec.CommandContext(ctx, "bash", "-c", query)
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stderr, cmd.Stdout = &stderr, &stdout
+	cmd.WaitDelay = QUERY_WAIT_DELAY
 
 	// Execute the query.
-	cmd_err := cmd.Start()
-	e(cmd_err)
+	start := time.Now()
+	cmd_err := cmd.Run()
+	result := storage.Result{
+		Duration: time.Since(start),
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Stderr:   strings.TrimSpace(stderr.String()),
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// The query didn't finish in time--record that it happened, discarding any partial output.
+		slog.Error("Query timed out", "query", query)
+		result.Status = storage.RESULT_STATUS_TIMEOUT
+		AddResult(query, result, history)
 
-	// Manage potential errors coming from the command itself.
-	cmd_stderr_output, cmd_stderr_output_err := io.ReadAll(stderr)
-	e(cmd_stderr_output_err)
-	if len(cmd_stderr_output) != 0 {
-		slog.Error("Query error", "query", query)
+		return true
+	}
+	if _, ok := cmd_err.(*exec.ExitError); !ok {
+		// Non-zero exits are the business of the query, but anything else is a problem with execution.
+		e(cmd_err)
 	}
 
-	// Interpret results.
-	cmd_output, cmd_output_err := io.ReadAll(stdout)
-	e(cmd_output_err)
+	// Manage potential errors coming from the command itself.
+	if result.ExitCode != 0 || result.Stderr != "" {
+		slog.Error("Query error", "query", query, "exitCode", result.ExitCode, "stderr", result.Stderr)
+	}
 
 	// Store results.
-	slog.Debug("Query success", "query", query, "result", cmd_output)
-	AddResult(query, string(cmd_output), history)
-
-	// Clean-up.
-	cmd.Wait()
+	slog.Debug("Query success", "query", query, "result", stdout.Bytes())
+	result.Value = stdout.String()
+	AddResult(query, result, history)
 
 	return true
 }
 
 // Executes a query as a process to profile.
-func runQueryProfile(pid string, history bool) bool {
+func runQueryProfile(ctx context.Context, pid string, history bool) bool {
 	var success = true
 
 	slog.Debug("Profiling pid", "pid", pid)
@@ -106,7 +154,9 @@ func runQueryProfile(pid string, history bool) bool {
 	e(err)
 
 	if _, err := os.FindProcess(pidInt); err != nil {
-		AddResult(pid, runProfile(pidInt), history)
+		start := time.Now()
+		value := runProfile(pidInt)
+		AddResult(pid, storage.Result{Duration: time.Since(start), Value: value}, history)
 	} else {
 		slog.Error("Pid not found", "pid", pid)
 		success = false
@@ -116,13 +166,13 @@ func runQueryProfile(pid string, history bool) bool {
 }
 
 // Reads standard input for results.
-func runQueryStdin(query string, history bool) bool {
+func runQueryStdin(ctx context.Context, query string, history bool) bool {
 	var success = true
 
 	slog.Debug("Reading stdin")
 
 	if stdinScanner.Scan() {
-		AddResult(query, stdinScanner.Text(), history)
+		AddResult(query, storage.Result{Value: stdinScanner.Text()}, history)
 	} else {
 		success = false
 	}
@@ -132,9 +182,9 @@ func runQueryStdin(query string, history bool) bool {
 
 // Entrypoint for 'query' mode.
 func Query(
-	queryMode, attempts, delay int,
+	queryMode, attempts, delay, timeout int,
 	queries []string,
-	port int,
+	queryConfigs map[string]QueryConfig,
 	history bool,
 	resultsReadyChan chan bool,
 ) (chan bool, map[string]chan bool) {
@@ -144,9 +194,6 @@ func Query(
 		pauseQueryChans = make(map[string]chan bool, len(queries)) // Signals query pausing.
 	)
 
-	// Start the RPC server.
-	initServer(fmt.Sprintf("%d", port))
-
 	go func() {
 		// Wait for result consumption to become ready.
 		slog.Debug("Waiting for results readiness")
@@ -165,6 +212,7 @@ func Query(
 					query,
 					attempts,
 					delay,
+					queryTimeout(query, timeout, queryConfigs),
 					history,
 					doneQueryChan,
 					pauseQueryChans[query],
@@ -182,6 +230,7 @@ func Query(
 					query,
 					attempts,
 					delay,
+					queryTimeout(query, timeout, queryConfigs),
 					history,
 					doneQueryChan,
 					pauseQueryChans[query],
@@ -199,6 +248,7 @@ func Query(
 				queries[0],
 				attempts,
 				delay,
+				0, // Reading standard input is never timed out.
 				history,
 				doneQueryChan,
 				pauseQueryChans[queries[0]],
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	queryConfigs := map[string]QueryConfig{
+		"foo": {Command: "foo", Timeout: 5},
+		"bar": {Command: "bar"},
+	}
+
+	// It prefers a query specific timeout.
+	got := queryTimeout("foo", 10, queryConfigs)
+	expected := 5
+	if got != expected {
+		t.Errorf("Got: %v Expected %v\n", got, expected)
+	}
+
+	// It falls back to the global timeout.
+	for _, query := range []string{"bar", "fizz"} {
+		got = queryTimeout(query, 10, queryConfigs)
+		expected = 10
+		if got != expected {
+			t.Errorf("Got: %v Expected %v\n", got, expected)
+		}
+	}
+}
+
+func TestRunQueryExecTimeout(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It stops a query, and anything it spawned, once the timeout has passed.
+	query := "sleep 10 | cat"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	runQueryExec(ctx, query, false)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Query ran for %v\n", elapsed)
+	}
+
+	// It records a timed out result.
+	results := store.GetAll(query)
+	if len(results) != 1 || results[0].Status != storage.RESULT_STATUS_TIMEOUT {
+		t.Errorf("Got: %v Expected status %v\n", results, storage.RESULT_STATUS_TIMEOUT)
+	}
+}
+
+func TestRunQueryExecMeta(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It records output, error output, and the exit code.
+	query := "echo foo; echo bar >&2; exit 3"
+	runQueryExec(context.Background(), query, false)
+
+	results := store.GetAll(query)
+	if len(results) != 1 {
+		t.Fatalf("Got: %v\n", results)
+	}
+	got := results[0]
+	if got.Value != "foo" || got.Stderr != "bar" || got.ExitCode != 3 || got.Duration <= 0 {
+		t.Errorf("Got: %v\n", got)
+	}
+}
@@ -3,9 +3,114 @@
 
 package lib
 
-func Read(port string) (done chan int) {
+import (
+	"log/slog"
+	"net/rpc"
+	"slices"
+	"time"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+// Follows results of a remote query, copying them into local storage as they arrive.
+func followQuery(query string, doneChan, pauseChan chan bool) {
+	var (
+		reply storage.ResultsRPC // Remote results.
+		since time.Time          // Time of the last result copied.
+	)
+
+	for {
+		select {
+		case <-pauseChan:
+			// Manage pausing. If we receive from the pause channel, wait for another message from the
+			// pause channel.
+			<-pauseChan
+		default:
+			// Only ask for results newer than those already copied.
+			if latest := store.GetLatest(query, 1); len(latest) > 0 {
+				since = latest[0].Time
+			}
+
+			reply = storage.ResultsRPC{}
+			err := client.Call("Storage.Follow", storage.ArgsRPC{Query: query, Since: since}, &reply)
+			if err == rpc.ErrShutdown {
+				// The remote Shui has gone away.
+				slog.Error("Lost connection to remote results", "query", query)
+				doneChan <- true
+				return
+			}
+			if err != nil {
+				e(err)
+				time.Sleep(time.Second)
+				continue
+			}
+			e(mirrorResults(query, &reply))
+		}
+	}
+}
+
+// Entrypoint for 'read' mode. Connects to a remote Shui and follows the results of its queries,
+// which are displayed as if they were local. If no queries are provided, all remote queries are
+// followed.
+func Read(
+	network, addr string,
+	queries []string,
+	resultsReadyChan chan bool,
+) (chan bool, map[string]chan bool, []string, error) {
+	var (
+		doneQueryChan   chan bool            // Signals specific query completions.
+		err             error                // General error holder.
+		foundQueries    []string             // Queries available remotely.
+		pauseQueryChans map[string]chan bool // Signals query pausing.
+
+		doneQueriesChan = make(chan bool) // Signals overall completion.
+	)
+
 	// Start the RPC client.
-	initClient(port)
+	if err = initClient(network, addr); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Determine queries to follow.
+	if foundQueries, err = remoteQueries(); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(queries) == 0 {
+		queries = foundQueries
+	}
+	doneQueryChan = make(chan bool, len(queries))
+	pauseQueryChans = make(map[string]chan bool, len(queries))
+	for _, query := range queries {
+		if !slices.Contains(foundQueries, query) {
+			slog.Warn("Query has no remote results yet", "query", query)
+		}
+
+		// Initialize pause channels.
+		pauseQueryChans[query] = make(chan bool)
+	}
+
+	go func() {
+		// Wait for result consumption to become ready. Existing remote results are copied before this.
+		slog.Debug("Waiting for results readiness")
+		<-resultsReadyChan
+
+		for _, query := range queries {
+			go followQuery(query, doneQueryChan, pauseQueryChans[query])
+		}
+	}()
+
+	// Begin the goroutine to wait for query completion.
+	go func() {
+		defer close(doneQueryChan)
+
+		// Wait for the queries to finish.
+		for i := 0; i < len(queries); i++ {
+			<-doneQueryChan
+		}
+
+		// Signal overall completion.
+		doneQueriesChan <- true
+	}()
 
-	return
+	return doneQueriesChan, pauseQueryChans, queries, nil
 }
@@ -11,10 +11,16 @@ package lib
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/scanner"
 	"time"
 	"unicode"
@@ -23,97 +29,289 @@ import (
 
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
+	"github.com/spacez320/shui/pkg/dsl"
 	"github.com/spacez320/shui/pkg/storage"
 )
 
+const (
+	DEFAULT_EXPR_STALENESS = 300 // Age past which other queries' results are left out of expressions.
+	DEFAULT_EXPR_WINDOW    = 60  // Number of recent results available to windowed expression functions.
+)
+
 var (
-	config          Config                          // Global configuration.
-	currentCtx      context.Context                 // Current context.
-	driver          DisplayDriver                   // Display driver, dictated by the results.
-	pauseQueryChans map[string]chan bool            // Channels for dealing with 'pause' events for results.
-	readerIndexes   map[string]*storage.ReaderIndex // Collection of reader index ids per query.
-	store           storage.Storage                 // Stored results.
+	config          Config                           // Global configuration.
+	currentCtx      context.Context                  // Current context.
+	driver          DisplayDriver                    // Display driver, dictated by the results.
+	elasticsearch   *storage.ElasticsearchStorage    // Elasticsearch integration, if enabled.
+	pauseQueryChans map[string]chan bool             // Channels for dealing with 'pause' events for results.
+	readerIndexes   map[string]*storage.ReaderIndex  // Collection of reader index ids per query.
+	store           storage.Storage                  // Stored results.
+	subscriptions   map[string]*storage.Subscription // Subscriptions to results per query, for displays.
 
 	ctxDefaults = map[string]interface{}{
 		"advanceDisplayMode": false,
 		"advanceQuery":       false,
+		"detach":             false,
 		"quit":               false,
+		"stop":               false,
 	} // Defaults applied to context.
-	pauseDisplayChan = make(chan bool) // Channel for dealing with 'pause' events for the display.
+	exprTypes = exprEnv(dsl.Window{}.Env(), map[string]interface{}{
+		"duration":   float64(0),
+		"exitCode":   0,
+		"prevResult": map[string]interface{}{},
+		"q":          map[string]interface{}{},
+		"result":     map[string]interface{}{},
+		"stderr":     "",
+	}) // Types of values available to expressions, for compiling them.
+	pauseDisplayChan = make(chan bool)              // Channel for dealing with 'pause' events for the display.
+	programs         = make(map[string]*vm.Program) // Compiled expressions, by expression.
+	programsMutex    = &sync.RWMutex{}              // Mutex for managing compiled expressions.
 )
 
-// Executes an expression on a result and returns a new result.
-//
-// TODO Currently this compiles expressions on the fly and constructs a result object on every
-// iteration. In the future, it might be important from a performance perspective to pre-compile
-// expressions and avoid constructing results before it's necessary.
-func exprResult(
+// Converts a single value output by an expression into a result value. Numbers keep their types,
+// while anything else becomes a string. Returns false for values that can't be used, like nil.
+func exprValue(output interface{}) (interface{}, bool) {
+	switch output.(type) {
+	case nil:
+		return nil, false
+	case int:
+		return int64(output.(int)), true
+	case int64, float64, string:
+		return output, true
+	case bool:
+		return strconv.FormatBool(output.(bool)), true
+	default:
+		return fmt.Sprint(output), true
+	}
+}
+
+// Converts expression output into result values. Maps produce a value for each key, labeled by
+// their keys, and arrays produce a value for each element, labeled by index. Any other output is a
+// single, unlabeled value. Elements that can't be used, like nil, are left out. Returns false for
+// output that can't be used.
+func exprValues(output interface{}) (values storage.Values, labels []string, ok bool) {
+	switch output.(type) {
+	case map[string]interface{}:
+		for label := range output.(map[string]interface{}) {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		usable := labels[:0] // Labels of usable values.
+		for _, label := range labels {
+			if value, ok := exprValue(output.(map[string]interface{})[label]); ok {
+				values, usable = append(values, value), append(usable, label)
+			}
+		}
+		return values, usable, len(values) > 0
+	case []interface{}:
+		for i, element := range output.([]interface{}) {
+			if value, ok := exprValue(element); ok {
+				values, labels = append(values, value), append(labels, strconv.Itoa(i))
+			}
+		}
+		return values, labels, len(values) > 0
+	default:
+		value, ok := exprValue(output)
+		return storage.Values{value}, nil, ok
+	}
+}
+
+// Provides a compiled expression, compiling it if it hasn't been already.
+func exprProgram(expression string) (program *vm.Program, err error) {
+	var (
+		ok bool // Whether the expression was already compiled.
+	)
+
+	programsMutex.RLock()
+	program, ok = programs[expression]
+	programsMutex.RUnlock()
+	if ok {
+		return
+	}
+
+	if program, err = expr.Compile(expression, expr.Env(exprTypes)); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid expression %s: %v", expression, err))
+	}
+	programsMutex.Lock()
+	programs[expression] = program
+	programsMutex.Unlock()
+
+	return
+}
+
+// Compiles expressions ahead of results, sharing them with every query.
+func CompileExpressions(expressions []string) (err error) {
+	for _, expression := range expressions {
+		if _, err = exprProgram(expression); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Combines values for an expression environment with those drawn from stored results.
+func exprEnv(stored, values map[string]interface{}) map[string]interface{} {
+	env := make(map[string]interface{}, len(stored)+len(values))
+	for k, v := range stored {
+		env[k] = v
+	}
+	for k, v := range values {
+		env[k] = v
+	}
+
+	return env
+}
+
+// Provides the parts of an expression environment drawn from stored results, shared by every
+// expression applied to a result.
+func exprStored(query string, result storage.Result) map[string]interface{} {
+	stored := exprWindow(query, result).Env()
+	stored["q"] = exprQueries(result)
+
+	return stored
+}
+
+// Provides the latest results of every query, and of derived series, as of the time of a result.
+// Each is available by the name of its query (or its command) and provides its first value, the
+// mapping of its values and its age relative to the result. Results older than the configured
+// staleness are left out, as they no longer describe the time of the result.
+func exprQueries(result storage.Result) map[string]interface{} {
+	var (
+		asOf  = result.Time                                       // Time results are aligned to.
+		names = make(map[string]string, len(config.QueryConfigs)) // Queries by their names.
+
+		queries = make(map[string]interface{}, len(readerIndexes)) // Latest results by name.
+	)
+
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	for query, queryConfig := range config.QueryConfigs {
+		names[query] = query
+		if queryConfig.Name != "" {
+			names[queryConfig.Name] = query
+		}
+	}
+	for _, query := range config.Queries {
+		names[query] = query
+	}
+	for _, derived := range config.Derived {
+		names[derived.Name] = derived.Name
+	}
+
+	for name, query := range names {
+		results := store.GetRange(query, time.Time{}, asOf)
+		if len(results) == 0 {
+			continue
+		}
+		latest := results[len(results)-1]
+		age := asOf.Sub(latest.Time)
+		if config.ExprStaleness > 0 && age > time.Duration(config.ExprStaleness)*time.Second {
+			continue
+		}
+
+		var value interface{} // First value of the result.
+		if len(latest.Values) > 0 {
+			value = latest.Values[0]
+		}
+		queries[name] = map[string]interface{}{
+			"age":    age.Seconds(),
+			"result": latest.Map(store.GetResultLabels(query, latest)),
+			"value":  value,
+		}
+	}
+
+	return queries
+}
+
+// Provides a window of the results of a query, up to and including a result.
+func exprWindow(query string, result storage.Result) (window dsl.Window) {
+	var (
+		size = config.ExprWindow // Number of results in the window.
+	)
+
+	window = dsl.Window{}
+	if size <= 0 {
+		size = DEFAULT_EXPR_WINDOW
+	}
+	if result.Time.IsZero() {
+		// Results without a time can't be placed in history.
+		return
+	}
+
+	results := store.GetRange(query, time.Time{}, result.Time)
+	for _, windowResult := range results[max(len(results)-size, 0):] {
+		window.Add(windowResult.Time, windowResult.Map(store.GetResultLabels(query, windowResult)))
+	}
+
+	return
+}
+
+// Executes an expression on a result and provides its output.
+func exprRun(
 	query, expression string,
 	result, prevResult storage.Result,
-) (newResult storage.Result, err error) {
+	stored map[string]interface{},
+) (output interface{}, err error) {
 	var (
 		env     map[string]interface{} // Environment to provide for an expression.
-		output  interface{}            // Output from an expression.
 		program *vm.Program            // Expression executable.
 	)
 
-	// Construct the expression environment.
-	env = map[string]interface{}{
-		"prevResult": prevResult.Map(store.GetLabels(query, []string{})),
-		"result":     result.Map(store.GetLabels(query, []string{})),
+	if program, err = exprProgram(expression); err != nil {
+		return
 	}
+
+	// Construct the expression environment.
+	env = exprEnv(stored, map[string]interface{}{
+		"duration":   result.Duration.Seconds(),
+		"exitCode":   result.ExitCode,
+		"prevResult": prevResult.Map(store.GetResultLabels(query, prevResult)),
+		"result":     result.Map(store.GetResultLabels(query, result)),
+		"stderr":     result.Stderr,
+	})
 	slog.Debug("Expression executing", "query", query, "expression", expression, "env", env)
 
-	// Execute any expression.
-	program, err = expr.Compile(expression, expr.Env(env))
-	if err != nil {
-		slog.Error("Failed to compile expression", "expr", expression, "env", env)
-		return result, err
-	}
+	// Execute the expression.
 	output, err = expr.Run(program, env)
 	if err != nil {
 		slog.Error("Expression failed to execute", "expr", expression, "env", env)
+	}
+
+	return
+}
+
+// Executes an expression on a result and returns a new result.
+func exprResult(
+	query, expression string,
+	result, prevResult storage.Result,
+	stored map[string]interface{},
+) (newResult storage.Result, err error) {
+	var (
+		ok     bool        // Whether the output could be used.
+		output interface{} // Output from an expression.
+	)
+
+	if output, err = exprRun(query, expression, result, prevResult, stored); err != nil {
 		return result, err
 	}
 
-	// Re-define result based on the expression output.
-	switch output.(type) {
-	case bool:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatBool(output.(bool)),
-			Values: storage.Values{strconv.FormatBool(output.(bool))},
-		}
-	case int:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.Itoa(output.(int)),
-			Values: storage.Values{strconv.Itoa(output.(int))},
-		}
-	case int64:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatInt(output.(int64), 10),
-			Values: storage.Values{strconv.FormatInt(output.(int64), 10)},
-		}
-	case float64:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  strconv.FormatFloat(output.(float64), 'f', -1, 64),
-			Values: storage.Values{strconv.FormatFloat(output.(float64), 'f', -1, 64)},
-		}
-	case string:
-		newResult = storage.Result{
-			Time:   result.Time,
-			Value:  output.(string),
-			Values: storage.Values{output.(string)},
-		}
-	default:
+	// Re-define result based on the expression output. Any rows of the result no longer apply.
+	newResult = result
+	newResult.Rows = nil
+	newResult.Values, newResult.Labels, ok = exprValues(output)
+	if !ok {
 		// The output type isn't one that may be processed by an expression (like nil), so return the
 		// result unmodified.
-		slog.Warn("Expression output not supported", "expr", expression, "env", env, "output", output)
-		newResult = result
+		return result, errors.New(
+			fmt.Sprintf("Expression output not supported: %s (output %v)", expression, output))
+	}
+	values := make([]string, len(newResult.Values))
+	for i, value := range newResult.Values {
+		values[i] = fmt.Sprint(value)
 	}
+	newResult.Value = strings.Join(values, " ")
 
 	return
 }
@@ -126,12 +324,40 @@ func resetContext(query string) {
 	currentCtx = context.WithValue(currentCtx, "query", query)
 }
 
-// Adds a result to the result store based on a string. It is assumed that all processing has
-// ocurred on the result itself.
-func AddResult(query, result string, history bool) {
-	result = strings.TrimSpace(result)
-	_, err := store.Put(query, result, history, TokenizeResult(result)...)
+// Adds a result to the result store. The result value is expected to be the raw output of a query,
+// which will be tokenized, while any other result fields (e.g. execution metadata) are preserved.
+func AddResult(query string, result storage.Result, history bool) {
+	var (
+		err    error            // General error holder.
+		labels []string         // Labels from parsing.
+		rows   []storage.Values // Rows of values from parsing.
+	)
+
+	result.Value = strings.TrimSpace(result.Value)
+
+	// Results from queries that didn't complete have no output to parse.
+	if result.Status == storage.RESULT_STATUS_OK {
+		labels, rows, err = parseResult(config.QueryConfigs[query], result.Value)
+		if err != nil {
+			slog.Error("Unable to parse result", "query", query, "err", err)
+		}
+		if len(labels) > 0 && len(config.Labels) == 0 {
+			// Labels provided explicitly take precedence over those from parsing.
+			e(store.PutLabels(query, labels))
+		}
+		if len(rows) > 0 {
+			result.Values = rows[0]
+		}
+		if len(rows) > 1 {
+			result.Rows = rows
+		}
+	}
+
+	result, err = store.PutResult(query, history, result)
 	e(err)
+
+	deriveResults(query, result, history)
+	evaluateAlerts(query, result, history)
 }
 
 // Get results previous to the last read result.
@@ -146,7 +372,7 @@ func GetPrevResults(query string, filters []string) (results []storage.Result) {
 func GetResult(query string, filters []string) (result storage.Result) {
 	slog.Debug("Fetching next result", "query", query)
 
-	return store.Next(query, filters, readerIndexes[query])
+	return store.Next(subscriptions[query], filters, readerIndexes[query])
 }
 
 // Returns a result after applying expressions. Requires a previous result for calculations
@@ -157,11 +383,20 @@ func ExprResult(
 	expressions []string,
 	result, prevResult storage.Result,
 ) storage.Result {
-	var err error // General error holder.
+	var (
+		err    error                  // General error holder.
+		stored map[string]interface{} // Environment drawn from stored results.
+	)
+
+	// Results from queries that didn't complete have nothing to apply expressions to.
+	if result.Status != storage.RESULT_STATUS_OK {
+		return result
+	}
 
 	// Process any expressions on the result.
+	stored = exprStored(query, result)
 	for _, expression := range expressions {
-		result, err = exprResult(query, expression, result, prevResult)
+		result, err = exprResult(query, expression, result, prevResult, stored)
 		if err != nil {
 			e(err)
 		}
@@ -173,7 +408,7 @@ func ExprResult(
 // Retrieves a next result, waiting for a non-empty return in a non-blocking manner.
 func GetResultWait(query string) (result storage.Result) {
 	for {
-		if result = store.NextOrEmpty(query, readerIndexes[query]); result.IsEmpty() {
+		if result = store.NextOrEmpty(subscriptions[query], readerIndexes[query]); result.IsEmpty() {
 			// Wait a tiny bit if we receive an empty result to avoid an excessive amount of busy waiting.
 			// This wait time should be less than the query delay, otherwise displays will show a release
 			// of buffered results.
@@ -204,18 +439,62 @@ func FilterResult(result storage.Result, filters, labels []string) storage.Resul
 	// Filter the results.
 	resultValues = FilterSlice(result.Values, labelIndexes)
 
-	return storage.Result{
-		Time:   result.Time,
-		Value:  result.Value,
-		Values: resultValues,
+	result.Values = resultValues
+
+	return result
+}
+
+// Finds the index of the key column of a query's results, or -1 if the query has no key.
+func keyIndex(query string, result storage.Result) int {
+	if config.QueryConfigs[query].Key == "" {
+		return -1
 	}
+
+	return slices.Index(store.GetResultLabels(query, result), config.QueryConfigs[query].Key)
+}
+
+// Splits a result into a result for each of its rows, keyed by the value of the query's key column.
+// Results of queries without a key aren't split, and have an empty key.
+func keyedResults(query string, result storage.Result) (keys []string, results []storage.Result) {
+	index := keyIndex(query, result)
+	if index < 0 {
+		return []string{""}, []storage.Result{result}
+	}
+
+	for _, row := range result.AllRows() {
+		if index >= len(row) {
+			// Rows without a key can't be told apart.
+			continue
+		}
+		keyedResult := result
+		keyedResult.Values, keyedResult.Rows = row, nil
+		keys = append(keys, fmt.Sprint(row[index]))
+		results = append(results, keyedResult)
+	}
+
+	return
+}
+
+// Types a token of output as an integer or float, or otherwise as a string.
+func tokenValue(token string) interface{} {
+	// Attempt to parse this value as an integer.
+	if tokenInt, err := strconv.ParseInt(token, 10, 0); err == nil {
+		return tokenInt
+	}
+
+	// Attempt to parse this value as a float.
+	if tokenFloat, err := strconv.ParseFloat(token, 10); err == nil {
+		return tokenFloat
+	}
+
+	// Everything else has failed--just pass it as a string.
+	return token
 }
 
 // Parses a result into tokens for compound storage.
 func TokenizeResult(result string) (parsedResult []interface{}) {
 	var (
-		s    scanner.Scanner // Scanner for tokenization.
-		next string          // Next token to consider.
+		s scanner.Scanner // Scanner for tokenization.
 	)
 
 	s.Init(strings.NewReader(result))
@@ -225,27 +504,138 @@ func TokenizeResult(result string) (parsedResult []interface{}) {
 	}
 
 	for token := s.Scan(); token != scanner.EOF; token = s.Scan() {
-		next = s.TokenText()
+		parsedResult = append(parsedResult, tokenValue(s.TokenText()))
+	}
+
+	return
+}
+
+// Initializes integrations that results are exported to.
+func initExternalStorages() {
+	var (
+		err         error                       // General error holder.
+		pushgateway storage.PushgatewayStorage  // Pushgateway configuration.
+		prometheus  storage.PrometheusStorage   // Prometheus configuration.
+		remoteWrite *storage.RemoteWriteStorage // Prometheus remote write configuration.
+
+		metrics = make(map[string][]storage.MetricConfig) // Prometheus metrics of each query.
+	)
 
-		// Attempt to parse this value as an integer.
-		nextInt, err := strconv.ParseInt(next, 10, 0)
+	if config.ElasticsearchAddr != "" {
+		elasticsearch, err = storage.NewElasticsearchStorage(
+			config.ElasticsearchAddr,
+			config.ElasticsearchIndex,
+			config.ElasticsearchPassword,
+			config.ElasticsearchUser,
+		)
+		e(err)
 		if err == nil {
-			parsedResult = append(parsedResult, nextInt)
-			continue
+			store.AddExternalStorage(elasticsearch)
 		}
-
-		// Attempt to parse this value as a float.
-		nextFloat, err := strconv.ParseFloat(next, 10)
+	}
+	for query, queryConfig := range config.QueryConfigs {
+		metrics[query] = queryConfig.Metrics
+	}
+	if config.PushgatewayAddr != "" {
+		pushgateway = storage.NewPushgatewayStorage(config.PushgatewayAddr, metrics)
+		store.AddExternalStorage(&pushgateway)
+	}
+	if config.PrometheusExporterAddr != "" {
+		prometheus = storage.NewPrometheusStorage(config.PrometheusExporterAddr, metrics)
+		store.AddExternalStorage(&prometheus)
+	}
+	if config.PrometheusRemoteWriteAddr != "" {
+		remoteWrite, err = storage.NewRemoteWriteStorage(config.PrometheusRemoteWriteAddr, metrics)
+		e(err)
 		if err == nil {
-			parsedResult = append(parsedResult, nextFloat)
-			continue
+			store.AddExternalStorage(remoteWrite)
 		}
+	}
+}
+
+// Initializes storage for results, along with anything that results are shared with.
+func initStorage(queries, labels []string, history bool) {
+	var (
+		err error // General error holder.
 
-		// Everything else has failed--just pass it as a string.
-		parsedResult = append(parsedResult, next)
+		retention = make(map[string]storage.RetentionPolicy) // Retention policies of each query.
+	)
+
+	// Initialize storage.
+	store, err = storage.NewStorage(history, storage.SyncPolicy(config.StorageSync))
+	e(err)
+
+	// Copy existing results from a remote Shui when reading, otherwise export results and allow
+	// others to read them, if asked to. Results being read are exported by the Shui producing them.
+	if client != nil {
+		e(mirrorAllResults(queries))
+	} else {
+		initExternalStorages()
+		if config.Serve {
+			e(initServer(config.RPCAddr()))
+		}
 	}
 
-	return
+	// Set up labelling or any schema for the results store, if any were explicitly provided. This
+	// must happen before any results are produced, so that labels apply to all of them.
+	if len(labels) > 0 {
+		for _, query := range queries {
+			e(store.PutLabels(query, labels))
+		}
+	}
+	for _, derived := range config.Derived {
+		// Single values of derived series are named for the series.
+		e(store.PutLabels(derived.Name, []string{derived.Name}))
+	}
+	for _, alert := range config.Alerts {
+		e(store.PutLabels(alert.Name, []string{ALERT_STATE_LABEL}))
+	}
+	for query, queryConfig := range config.QueryConfigs {
+		if !queryConfig.Retention.IsEmpty() {
+			retention[query] = queryConfig.Retention
+		}
+	}
+	e(store.PutRetention(retention))
+
+	initAlerts()
+}
+
+// Entry-point function for results when running in the background. Results are stored, exported,
+// and served, but never displayed. Runs until stopped, either by a signal or by a client.
+func Headless(
+	ctx context.Context,
+	history bool,
+	inputConfig *Config,
+	resultsReadyChan chan bool,
+) {
+	var (
+		signalChan = make(chan os.Signal, 1) // Signals to stop on.
+		stopChan   = make(chan bool, 1)      // Requests to stop from clients.
+
+		labels  = ctx.Value("labels").([]string)  // Capture labels from context.
+		queries = ctx.Value("queries").([]string) // Capture queries from context.
+	)
+
+	// Assign global config.
+	config = *inputConfig
+
+	initStorage(queries, labels, history)
+	e(initControl(stopChan))
+
+	// Signals that results are ready to be received.
+	slog.Debug("Results are ready")
+	resultsReadyChan <- true
+
+	// Stop cleanly, so that results aren't lost and sockets aren't left behind.
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signalChan:
+	case <-stopChan:
+	}
+	slog.Info("Stopping")
+	closeServer()
+	store.Close()
+	os.Exit(0)
 }
 
 // Entry-point function for results.
@@ -260,11 +650,6 @@ func Results(
 	resultsReadyChan chan bool,
 ) {
 	var (
-		err           error                        // General error holder.
-		elasticsearch storage.ElasticsearchStorage // Elasticsearch configuration.
-		pushgateway   storage.PushgatewayStorage   // Pushgateway configuration.
-		prometheus    storage.PrometheusStorage    // Prometheus configuration.
-
 		expressions = ctx.Value("expressions").([]string) // Capture expressions from context.
 		filters     = ctx.Value("filters").([]string)     // Capture filters from context.
 		labels      = ctx.Value("labels").([]string)      // Capture labels from context.
@@ -278,34 +663,25 @@ func Results(
 		defer close(pauseQueryChan)
 	}
 
-	// Initialize storage.
-	store, err = storage.NewStorage(history)
-	e(err)
+	initStorage(queries, labels, history)
 	defer store.Close()
 
-	// Initialize external storage.
-	if config.ElasticsearchAddr != "" {
-		elasticsearch = storage.NewElasticsearchStorage(
-			config.ElasticsearchAddr,
-			config.ElasticsearchIndex,
-			config.ElasticsearchPassword,
-			config.ElasticsearchUser,
-		)
-		store.AddExternalStorage(&elasticsearch)
-	}
-	if config.PushgatewayAddr != "" {
-		pushgateway = storage.NewPushgatewayStorage(config.PushgatewayAddr)
-		store.AddExternalStorage(&pushgateway)
-	}
-	if config.PrometheusExporterAddr != "" {
-		prometheus = storage.NewPrometheusStorage(config.PrometheusExporterAddr)
-		store.AddExternalStorage(&prometheus)
+	// Derived series are displayed alongside queries, unless they're already among remote results.
+	if client == nil {
+		for _, derived := range config.Derived {
+			if !slices.Contains(queries, derived.Name) {
+				queries = append(queries, derived.Name)
+			}
+		}
 	}
 
-	// Initialize reader indexes.
+	// Initialize reader indexes and subscriptions.
 	readerIndexes = make(map[string]*storage.ReaderIndex, len(queries))
+	subscriptions = make(map[string]*storage.Subscription, len(queries))
 	for _, query := range queries {
 		readerIndexes[query] = store.NewReaderIndex(query)
+		subscriptions[query] = store.Subscribe(
+			query, storage.PUT_EVENT_CHANNEL_SIZE, storage.DROP_POLICY_NEWEST)
 	}
 
 	// Signals that results are ready to be received.
@@ -317,11 +693,6 @@ func Results(
 		currentCtx = ctx
 		resetContext(query)
 
-		// Set up labelling or any schema for the results store, if any were explicitly provided.
-		if len(labels) > 0 {
-			store.PutLabels(query, labels)
-		}
-
 		switch displayMode {
 		case DISPLAY_MODE_RAW:
 			driver = DISPLAY_RAW
@@ -342,6 +713,15 @@ func Results(
 			}
 			driver = DISPLAY_TERMDASH
 			GraphDisplay(query, filters[0], expressions, displayConfig)
+		case DISPLAY_MODE_CHART:
+			driver = DISPLAY_TERMDASH
+			ChartDisplay(query, queries, filters, expressions, displayConfig)
+		case DISPLAY_MODE_DASHBOARD:
+			driver = DISPLAY_TERMDASH
+			DashboardDisplay(query, queries, filters, expressions, displayConfig)
+		case DISPLAY_MODE_DIFF:
+			driver = DISPLAY_TVIEW
+			DiffDisplay(query, filters, expressions, displayConfig)
 		default:
 			slog.Error("Invalid result driver", "displayMode", displayMode)
 			os.Exit(1)
@@ -350,8 +730,14 @@ func Results(
 		// If we get here, it's because the display functions have returned, probably because of an
 		// interrupt. Assuming we haven't reached some other terminal situation, restart the results
 		// display, adjusting for context.
-		if currentCtx.Value("quit").(bool) {
-			// Guess I'll die.
+		if currentCtx.Value("stop").(bool) {
+			// Stop the Shui we're attached to, along with this one.
+			stopRemote()
+			displayQuit()
+			os.Exit(0)
+		}
+		if currentCtx.Value("quit").(bool) || currentCtx.Value("detach").(bool) {
+			// Guess I'll die. Any Shui we're attached to is left running.
 			displayQuit()
 			os.Exit(0)
 		}
@@ -43,3 +43,218 @@ func TestFilterResult(t *testing.T) {
 		t.Errorf("Got: %v Expected %v\n", got, expected)
 	}
 }
+
+func TestKeyedResults(t *testing.T) {
+	var err error
+
+	config = Config{QueryConfigs: map[string]QueryConfig{"foo": {Command: "foo", Key: "disk"}}}
+	defer func() { config = Config{} }()
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutLabels("foo", []string{"disk", "used"})
+	result := storage.Result{
+		Time:   time.Now(),
+		Values: storage.Values{"sda", int64(1)},
+		Rows:   []storage.Values{{"sda", int64(1)}, {"sdb", int64(2)}},
+	}
+
+	// It splits results into a result for each row, keyed by the key column.
+	keys, results := keyedResults("foo", result)
+	expectedKeys := []string{"sda", "sdb"}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Got: %v Expected: %v\n", keys, expectedKeys)
+	}
+	if got := results[1].Values; !reflect.DeepEqual(got, result.Rows[1]) || results[1].Rows != nil {
+		t.Errorf("Got: %v Expected: %v\n", got, result.Rows[1])
+	}
+
+	// It doesn't split results of queries without a key.
+	keys, results = keyedResults("bar", result)
+	if !reflect.DeepEqual(keys, []string{""}) || !reflect.DeepEqual(results[0], result) {
+		t.Errorf("Got: %v %v Expected: %v\n", keys, results, result)
+	}
+}
+
+func TestAddResult(t *testing.T) {
+	var err error
+
+	config = Config{
+		QueryConfigs: map[string]QueryConfig{"foo": {Parser: "json", Paths: []string{"a"}}},
+	}
+	defer func() { config = Config{} }()
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It parses output of results that completed.
+	AddResult("foo", storage.Result{Value: `{"a": 1}`}, false)
+	results := store.GetAll("foo")
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Values, storage.Values{int64(1)}) {
+		t.Errorf("Got: %v Expected: %v\n", results, storage.Values{int64(1)})
+	}
+
+	// It stores results that didn't complete without parsing them.
+	AddResult("foo", storage.Result{Status: storage.RESULT_STATUS_TIMEOUT}, false)
+	results = store.GetAll("foo")
+	if len(results) != 2 || len(results[1].Values) != 0 {
+		t.Errorf("Got: %v Expected: %v\n", results, "an unparsed second result")
+	}
+}
+
+func TestExprResult(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutLabels("foo", []string{"fizz", "buzz"})
+	result := storage.Result{Time: time.Now(), Values: storage.Values{int64(2), "bar"}}
+
+	// It keeps the types of single values.
+	got := ExprResult("foo", []string{"result.fizz * 2"}, result, storage.Result{})
+	if expected := (storage.Values{int64(4)}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+
+	// It labels values from maps by key, which later expressions may refer to.
+	got = ExprResult(
+		"foo",
+		[]string{`{"double": result.fizz * 2, "name": result.buzz}`, `{"half": result.double / 2}`},
+		result,
+		storage.Result{},
+	)
+	if expected := (storage.Values{float64(2)}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+	if expected := []string{"half"}; !reflect.DeepEqual(got.Labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Labels, expected)
+	}
+
+	// It labels values from arrays by index.
+	got = ExprResult("foo", []string{"[result.buzz, true]"}, result, storage.Result{})
+	if expected := (storage.Values{"bar", "true"}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+	if expected := []string{"0", "1"}; !reflect.DeepEqual(got.Labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Labels, expected)
+	}
+
+	// It leaves out elements of maps and arrays that can't be used.
+	got = ExprResult(
+		"foo", []string{`{"a": nil, "b": result.fizz}`, `[nil, result.b]`}, result, storage.Result{})
+	if expected := (storage.Values{int64(2)}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+	if expected := []string{"1"}; !reflect.DeepEqual(got.Labels, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Labels, expected)
+	}
+}
+
+func TestCompileExpressions(t *testing.T) {
+	// It compiles expressions once, ahead of use.
+	if err := CompileExpressions([]string{"get(result, 'fizz') * 100"}); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+	if _, ok := programs["get(result, 'fizz') * 100"]; !ok {
+		t.Errorf("Got: %v Expected: %v\n", ok, true)
+	}
+
+	// It fails on invalid expressions.
+	if err := CompileExpressions([]string{"result +"}); err == nil {
+		t.Errorf("Got: %v Expected: an error\n", err)
+	}
+}
+
+func TestExprResultWindow(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutLabels("foo", []string{"fizz"})
+	start := time.Now()
+	for i, value := range []int64{1, 3, 8} {
+		_, err = store.PutResult("foo", false, storage.Result{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Values: storage.Values{value},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := store.GetAll("foo")
+
+	// It provides functions over results up to the one expressions apply to.
+	got := ExprResult("foo", []string{`[delta("fizz"), max_over("fizz")]`}, results[1], results[0])
+	if expected := (storage.Values{float64(2), float64(3)}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+
+	// It bounds windows to a number of results.
+	config = Config{ExprWindow: 2}
+	defer func() { config = Config{} }()
+	got = ExprResult("foo", []string{`avg_over("fizz")`}, results[2], results[1])
+	if expected := (storage.Values{5.5}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+}
+
+func TestExprResultQueries(t *testing.T) {
+	var err error
+
+	store, err = storage.NewStorage(false, storage.SYNC_POLICY_NEVER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = Config{
+		ExprStaleness: 60,
+		Queries:       []string{"df used", "df total"},
+		QueryConfigs:  map[string]QueryConfig{"df used": {Name: "used"}},
+	}
+	defer func() { config = Config{} }()
+	store.PutLabels("df used", []string{"used"})
+	store.PutLabels("df total", []string{"total"})
+	start := time.Now()
+	for i, value := range []int64{10, 20, 30} {
+		_, err = store.PutResult("df used", false, storage.Result{
+			Time:   start.Add(time.Duration(i*50) * time.Second),
+			Values: storage.Values{value},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = store.PutResult("df total", false, storage.Result{
+		Time:   start.Add(-10 * time.Second),
+		Values: storage.Values{int64(100)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := store.GetAll("df used")
+
+	// It provides the latest results of other queries by name, as of the result.
+	got := ExprResult(
+		"df used", []string{`q["used"].value / q["df total"].value`}, results[1], results[0])
+	if expected := (storage.Values{0.2}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+
+	// It provides the age of results and their labeled values.
+	got = ExprResult(
+		"df used", []string{`[q["df total"].age, q["used"].result.used]`}, results[0], storage.Result{})
+	if expected := (storage.Values{float64(10), int64(10)}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+
+	// It leaves out results older than the staleness.
+	got = ExprResult("df used", []string{`"df total" in q`}, results[2], results[1])
+	if expected := (storage.Values{"false"}); !reflect.DeepEqual(got.Values, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values, expected)
+	}
+}
@@ -1,26 +1,67 @@
 //
 // Server for RPC.
 //
-// TODO RPC is currently disabled.
+// A running Shui exposes its storage so that other Shuis, in 'read' mode, may display its results.
 
 package lib
 
-// import (
-// 	"fmt"
-// 	"log/slog"
-// 	"net"
-// 	"net/http"
-// 	"net/rpc"
-// )
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+
+	"github.com/spacez320/shui/pkg/storage"
+)
+
+var (
+	listener net.Listener // Listener for RPC connections.
+	server   *rpc.Server  // RPC server.
+)
+
+// Stops accepting RPC connections. Unix sockets are removed.
+func closeServer() {
+	if listener != nil {
+		listener.Close()
+	}
+}
 
 // Establish the RPC server to allow access to stored results.
-func initServer(port string) {
-	// rpc.Register(&store)
-	// rpc.HandleHTTP()
-	//
-	// listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
-	// e(err)
-	//
-	// slog.Debug(fmt.Sprintf("Listening on :%v.\n", port))
-	// go http.Serve(listener, nil)
+func initServer(network, addr string) (err error) {
+	server = rpc.NewServer()
+	if err = server.RegisterName("Storage", storage.NewStorageRPC(&store)); err != nil {
+		return
+	}
+
+	// Clean-up sockets left behind by Shuis that didn't exit cleanly, as long as nothing is still
+	// listening on them.
+	if network == "unix" {
+		if stat, statErr := os.Stat(addr); statErr == nil && stat.Mode()&fs.ModeSocket != 0 {
+			if conn, dialErr := net.Dial(network, addr); dialErr == nil {
+				conn.Close()
+				return errors.New("Socket is already in use")
+			}
+			os.Remove(addr)
+		}
+	}
+
+	listener, err = net.Listen(network, addr)
+	if err != nil {
+		return
+	}
+	if network == "unix" {
+		// Only the user running Shui may read its results, or control it.
+		if err = os.Chmod(addr, fs.FileMode(0600)); err != nil {
+			listener.Close()
+			return
+		}
+	}
+
+	slog.Debug("Serving results", "network", network, "addr", addr)
+	go http.Serve(listener, server)
+
+	return
 }
@@ -1,16 +1,178 @@
 //
-// TODO This is a placeholder package for future Shui-defined expressions not provided by the
-// expression language. Functions here in its current state are just examples and for testing.
+// Shui-defined functions for expressions, beyond those provided by the expression language.
+//
+// Functions here work on windows of recent values, so that expressions may find rates, moving
+// averages and the like without keeping track of history themselves.
 
 package dsl
 
 import (
 	"fmt"
-	_ "log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/expr-lang/expr"
 )
 
+// Value of a label at a point in time.
+type Sample struct {
+	Time  time.Time // Time the value was observed.
+	Value float64   // The value itself.
+}
+
+// Recent samples for each label, oldest first.
+type Window map[string][]Sample
+
+// Converts a value into a number for windows. Strings, such as expression output, are parsed.
+// Returns false for values that aren't numbers.
+func number(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case int:
+		return float64(value.(int)), true
+	case int64:
+		return float64(value.(int64)), true
+	case float64:
+		return value.(float64), true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value.(string)), 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// Adds labeled values observed at a point in time. Values that aren't numbers are skipped.
+func (w Window) Add(t time.Time, values map[string]interface{}) {
+	for label, value := range values {
+		if number, ok := number(value); ok {
+			w[label] = append(w[label], Sample{t, number})
+		}
+	}
+}
+
+// Provides the values of each label, oldest first.
+func (w Window) Values() map[string][]float64 {
+	values := make(map[string][]float64, len(w))
+	for label, samples := range w {
+		values[label] = make([]float64, len(samples))
+		for i, sample := range samples {
+			values[label][i] = sample.Value
+		}
+	}
+
+	return values
+}
+
+// Provides variables and functions for an expression environment. Functions take the label of the
+// values they work on.
+func (w Window) Env() map[string]interface{} {
+	return map[string]interface{}{
+		"window": w.Values(),
+
+		"avg_over": func(label string) float64 { return AvgOver(w[label]) },
+		"delta":    func(label string) float64 { return Delta(w[label]) },
+		"ewma":     func(label string, alpha float64) float64 { return Ewma(w[label], alpha) },
+		"max_over": func(label string) float64 { return MaxOver(w[label]) },
+		"quantile_over": func(q float64, label string) float64 {
+			return QuantileOver(q, w[label])
+		},
+		"rate": func(label string) float64 { return Rate(w[label]) },
+	}
+}
+
+// Finds the mean of samples. There is no mean without samples.
+func AvgOver(samples []Sample) float64 {
+	var (
+		sum float64 // Sum of sample values.
+	)
+
+	if len(samples) == 0 {
+		return math.NaN()
+	}
+	for _, sample := range samples {
+		sum += sample.Value
+	}
+
+	return sum / float64(len(samples))
+}
+
+// Finds the change between the first and last samples. There is no change without two samples.
+func Delta(samples []Sample) float64 {
+	if len(samples) < 2 {
+		return math.NaN()
+	}
+
+	return samples[len(samples)-1].Value - samples[0].Value
+}
+
+// Finds the exponentially weighted moving average of samples, where alpha is the weight of each
+// newer sample, between 0 and 1. There is no average without samples.
+func Ewma(samples []Sample, alpha float64) float64 {
+	if len(samples) == 0 || alpha < 0 || alpha > 1 {
+		return math.NaN()
+	}
+
+	average := samples[0].Value
+	for _, sample := range samples[1:] {
+		average = alpha*sample.Value + (1-alpha)*average
+	}
+
+	return average
+}
+
+// Finds the largest sample. There is no largest without samples.
+func MaxOver(samples []Sample) float64 {
+	if len(samples) == 0 {
+		return math.NaN()
+	}
+
+	largest := samples[0].Value
+	for _, sample := range samples[1:] {
+		largest = max(largest, sample.Value)
+	}
+
+	return largest
+}
+
+// Finds the q-quantile of samples, where q is between 0 and 1, interpolating between the closest
+// samples. There is no quantile without samples.
+func QuantileOver(q float64, samples []Sample) float64 {
+	if len(samples) == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = sample.Value
+	}
+	slices.Sort(values)
+
+	rank := q * float64(len(values)-1)
+	lower, upper := int(math.Floor(rank)), int(math.Ceil(rank))
+
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// Finds the change per second between the first and last samples. There is no rate without two
+// samples apart in time.
+func Rate(samples []Sample) float64 {
+	if len(samples) < 2 {
+		return math.NaN()
+	}
+
+	seconds := samples[len(samples)-1].Time.Sub(samples[0].Time).Seconds()
+	if seconds <= 0 {
+		return math.NaN()
+	}
+
+	return Delta(samples) / seconds
+}
+
+// TODO The functions below are just examples and for testing.
+
 func addOneToNumber(i int) int {
 	return i + 1
 }
@@ -0,0 +1,60 @@
+package dsl
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	start := time.Date(2024, time.June, 10, 17, 40, 29, 0, time.UTC)
+	window := Window{}
+
+	// It keeps numeric values of each label, skipping anything else.
+	window.Add(start, map[string]interface{}{"foo": int64(1), "bar": "baz"})
+	window.Add(start.Add(2*time.Second), map[string]interface{}{"foo": "5", "bar": 2.5})
+	expected := map[string][]float64{"foo": {1, 5}, "bar": {2.5}}
+	if got := window.Values(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It provides functions on the values of labels.
+	if got := window.Env()["rate"].(func(string) float64)("foo"); got != 2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	start := time.Date(2024, time.June, 10, 17, 40, 29, 0, time.UTC)
+	samples := []Sample{}
+	for i, value := range []float64{4, 1, 3, 2} {
+		samples = append(samples, Sample{start.Add(time.Duration(i) * time.Second), value})
+	}
+
+	// It summarizes samples.
+	for _, test := range []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"avg_over", AvgOver(samples), 2.5},
+		{"delta", Delta(samples), -2},
+		{"ewma", Ewma(samples, 0.5), 2.375},
+		{"max_over", MaxOver(samples), 4},
+		{"quantile_over", QuantileOver(0.5, samples), 2.5},
+		{"quantile_over", QuantileOver(1, samples), 4},
+		{"rate", Rate(samples), -2.0 / 3},
+	} {
+		if test.got != test.expected {
+			t.Errorf("%s Got: %v Expected: %v\n", test.name, test.got, test.expected)
+		}
+	}
+
+	// It has no values without enough samples.
+	for _, got := range []float64{AvgOver(nil), Delta(samples[:1]), Rate(samples[:1])} {
+		if !math.IsNaN(got) {
+			t.Errorf("Got: %v Expected: %v\n", got, math.NaN())
+		}
+	}
+}
@@ -0,0 +1,357 @@
+//
+// Elasticsearch integration.
+//
+// Results are buffered as documents and indexed in batches with the Bulk API, whenever a batch is
+// full and periodically otherwise. Documents Elasticsearch can't take right now are retried with
+// backoff, while those it rejects, or that never make it, are written to a dead-letter file so
+// that they may be indexed by hand.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+
+package storage
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	ELASTICSEARCH_BATCH_BYTES        = 5 << 20                        // Most bytes per request.
+	ELASTICSEARCH_BATCH_SIZE         = 500                            // Most documents per request.
+	ELASTICSEARCH_DEAD_LETTER_FORMAT = "elasticsearch-%s.dead-letter" // Dead-letter filename format.
+	ELASTICSEARCH_FLUSH_INTERVAL     = 5 * time.Second                // Interval for partial batches.
+	ELASTICSEARCH_MAX_BACKOFF        = 30 * time.Second               // Longest delay between retries.
+	ELASTICSEARCH_MAX_BUFFER         = 10000                          // Most documents buffered.
+	ELASTICSEARCH_MAX_RETRIES        = 5                              // Retries before giving up.
+	ELASTICSEARCH_MIN_BACKOFF        = time.Second                    // Delay before the first retry.
+)
+
+// Delivery of documents to Elasticsearch so far.
+type ElasticsearchStats struct {
+	Failed  int // Documents written to the dead-letter file.
+	Indexed int // Documents indexed.
+	Pending int // Documents buffered or being sent.
+	Retried int // Retries of documents.
+}
+
+// Elasticsearch specific external storage system.
+type ElasticsearchStorage struct {
+	backoff        time.Duration         // Delay before the first retry.
+	client         *elasticsearch.Client // Client for requests to Elasticsearch.
+	deadLetterPath string                // Path of the dead-letter file.
+	flushChan      chan bool             // Signals that a full batch is buffered.
+	index          string                // Index to supply documents to.
+	mutex          *sync.Mutex           // Mutex for managing documents and stats.
+	pending        [][]byte              // Documents waiting to be sent, oldest first.
+	pendingBytes   int                   // Size of documents waiting to be sent.
+	stats          ElasticsearchStats    // Delivery so far.
+}
+
+// Outcome of indexing a document in a Bulk API response.
+type elasticsearchBulkItem struct {
+	Error  json.RawMessage `json:"error"`  // Reason the document wasn't indexed.
+	Status int             `json:"status"` // HTTP status of the document.
+}
+
+// Response of the Bulk API.
+type elasticsearchBulkResponse struct {
+	Errors bool                               `json:"errors"` // Whether any document failed.
+	Items  []map[string]elasticsearchBulkItem `json:"items"`  // Outcomes, by action, in order.
+}
+
+// Buffers a result as a document to be indexed. Documents are refused once too many are waiting,
+// going straight to the dead-letter file.
+func (e *ElasticsearchStorage) Put(query string, labels []string, result Result) error {
+	var (
+		err     error  // General error holder.
+		payload []byte // Document of the result.
+	)
+
+	// Build the document body.
+	payload, err = resultToElasticsearchDocument(query, labels, result)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Buffering for Elasticsearch", "result", result)
+	(*e).mutex.Lock()
+	defer (*e).mutex.Unlock()
+
+	if len((*e).pending) >= ELASTICSEARCH_MAX_BUFFER {
+		err = errors.New(fmt.Sprintf("Elasticsearch buffer for %s is full", (*e).index))
+		(*e).deadLetter([][]byte{payload}, err.Error())
+		return err
+	}
+	(*e).pending = append((*e).pending, payload)
+	(*e).pendingBytes += len(payload)
+	(*e).stats.Pending++
+	if len((*e).pending) >= ELASTICSEARCH_BATCH_SIZE ||
+		(*e).pendingBytes >= ELASTICSEARCH_BATCH_BYTES {
+		select {
+		case (*e).flushChan <- true:
+		default:
+			// A flush is already due.
+		}
+	}
+
+	return nil
+}
+
+// Provides delivery of documents so far.
+func (e *ElasticsearchStorage) Stats() ElasticsearchStats {
+	(*e).mutex.Lock()
+	defer (*e).mutex.Unlock()
+
+	return (*e).stats
+}
+
+// Sends the oldest batch of buffered documents, retrying those that Elasticsearch can't take right
+// now with backoff. Documents that are rejected, or still fail once out of retries, are written to
+// the dead-letter file. Returns whether documents remain buffered.
+func (e *ElasticsearchStorage) flush() bool {
+	var (
+		batch     [][]byte // Documents being sent.
+		batchSize int      // Size of documents being sent.
+		err       error    // Error of the last attempt.
+
+		backoff = (*e).backoff // Delay before the next retry.
+	)
+
+	(*e).mutex.Lock()
+	for len(batch) < min(len((*e).pending), ELASTICSEARCH_BATCH_SIZE) {
+		next := (*e).pending[len(batch)]
+		if len(batch) > 0 && batchSize+len(next) > ELASTICSEARCH_BATCH_BYTES {
+			break
+		}
+		batch, batchSize = append(batch, next), batchSize+len(next)
+	}
+	(*e).pending, (*e).pendingBytes = (*e).pending[len(batch):], (*e).pendingBytes-batchSize
+	(*e).mutex.Unlock()
+	if len(batch) == 0 {
+		return false
+	}
+
+	for retries := 0; ; retries++ {
+		sent := len(batch)
+		batch, err = (*e).bulk(batch)
+		(*e).mutex.Lock()
+		(*e).stats.Pending -= sent - len(batch)
+		(*e).mutex.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+
+		(*e).mutex.Lock()
+		if retries == ELASTICSEARCH_MAX_RETRIES {
+			(*e).deadLetter(batch, fmt.Sprintf("Out of retries: %v", err))
+			(*e).stats.Pending -= len(batch)
+			(*e).mutex.Unlock()
+			break
+		}
+		(*e).stats.Retried += len(batch)
+		(*e).mutex.Unlock()
+
+		slog.Warn("Retrying Elasticsearch documents",
+			"documents", len(batch), "err", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, ELASTICSEARCH_MAX_BACKOFF)
+	}
+
+	(*e).mutex.Lock()
+	defer (*e).mutex.Unlock()
+
+	return len((*e).pending) > 0
+}
+
+// Indexes documents with the Bulk API. Returns documents worth retrying, along with why.
+func (e *ElasticsearchStorage) bulk(documents [][]byte) (retry [][]byte, err error) {
+	var (
+		body   bytes.Buffer              // Request body, alternating actions and documents.
+		parsed elasticsearchBulkResponse // Response body.
+	)
+
+	for _, document := range documents {
+		body.WriteString("{\"index\":{}}\n")
+		body.Write(document)
+		body.WriteString("\n")
+	}
+
+	response, err := (*e).client.Bulk(&body, (*e).client.Bulk.WithIndex((*e).index))
+	if err != nil {
+		return documents, err
+	}
+	defer response.Body.Close()
+
+	// Requests failing as a whole fail for every document.
+	if response.IsError() {
+		message, _ := io.ReadAll(response.Body)
+		err = errors.New(fmt.Sprintf("Unexpected Elasticsearch response: %s %s",
+			response.Status(), bytes.TrimSpace(message)))
+		if elasticsearchRetryable(response.StatusCode) {
+			return documents, err
+		}
+		(*e).mutex.Lock()
+		(*e).deadLetter(documents, err.Error())
+		(*e).mutex.Unlock()
+		return nil, err
+	}
+	if err = json.NewDecoder(response.Body).Decode(&parsed); err != nil {
+		return documents, err
+	}
+	if len(parsed.Items) != len(documents) {
+		return documents, errors.New(fmt.Sprintf(
+			"Elasticsearch responded for %d of %d documents", len(parsed.Items), len(documents)))
+	}
+
+	(*e).mutex.Lock()
+	defer (*e).mutex.Unlock()
+
+	for i, item := range parsed.Items {
+		for _, outcome := range item {
+			switch {
+			case outcome.Status < 300:
+				(*e).stats.Indexed++
+			case elasticsearchRetryable(outcome.Status):
+				retry = append(retry, documents[i])
+				err = errors.New(fmt.Sprintf("Elasticsearch document status %d", outcome.Status))
+			default:
+				(*e).deadLetter(documents[i:i+1], string(outcome.Error))
+			}
+		}
+	}
+
+	return
+}
+
+// Writes documents that couldn't be indexed to the dead-letter file, one JSON object per line
+// along with why. Expects the mutex to be held.
+func (e *ElasticsearchStorage) deadLetter(documents [][]byte, reason string) {
+	var (
+		lines bytes.Buffer // Entries of the documents.
+	)
+
+	slog.Error("Writing Elasticsearch documents to dead-letter file",
+		"documents", len(documents), "path", (*e).deadLetterPath, "reason", reason)
+	(*e).stats.Failed += len(documents)
+
+	for _, document := range documents {
+		line, err := json.Marshal(map[string]interface{}{
+			"document": json.RawMessage(document),
+			"index":    (*e).index,
+			"reason":   reason,
+			"time":     time.Now(),
+		})
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		lines.Write(append(line, '\n'))
+	}
+
+	file, err := os.OpenFile(
+		(*e).deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fs.FileMode(0660))
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	defer file.Close()
+	if _, err = file.Write(lines.Bytes()); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// Sends buffered documents, whenever a batch is full and periodically otherwise.
+func (e *ElasticsearchStorage) run() {
+	ticker := time.NewTicker(ELASTICSEARCH_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-(*e).flushChan:
+		}
+		for (*e).flush() {
+		}
+	}
+}
+
+// Whether Elasticsearch may take documents later that it couldn't now.
+func elasticsearchRetryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Create an Elasticsearch storage writing documents that can't be indexed to a path.
+func newElasticsearchStorage(
+	address, index, password, user, deadLetterPath string,
+) (storage *ElasticsearchStorage, err error) {
+	var (
+		client *elasticsearch.Client // Client for requests to Elasticsearch.
+	)
+
+	// Retries are managed here, for each document.
+	client, err = elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{address},
+		DisableRetry: true,
+		Password:     password,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+		Username: user,
+	})
+	if err != nil {
+		return
+	}
+
+	storage = &ElasticsearchStorage{
+		backoff:        ELASTICSEARCH_MIN_BACKOFF,
+		client:         client,
+		deadLetterPath: deadLetterPath,
+		flushChan:      make(chan bool, 1),
+		index:          index,
+		mutex:          &sync.Mutex{},
+	}
+
+	return
+}
+
+// Creates a new storage for Elasticsearch. Documents that can't be indexed are written to a
+// dead-letter file in the user cache directory.
+func NewElasticsearchStorage(
+	address, index, password, user string,
+) (storage *ElasticsearchStorage, err error) {
+	var (
+		userCacheDir string // User cache directory, contextual to OS.
+	)
+
+	if userCacheDir, err = os.UserCacheDir(); err != nil {
+		return
+	}
+	deadLetterDir := filepath.Join(userCacheDir, STORAGE_FILE_DIR)
+	if err = os.MkdirAll(deadLetterDir, fs.FileMode(0770)); err != nil {
+		return
+	}
+
+	storage, err = newElasticsearchStorage(address, index, password, user, filepath.Join(
+		deadLetterDir, fmt.Sprintf(ELASTICSEARCH_DEAD_LETTER_FORMAT, normalizeString(index))))
+	if err != nil {
+		return
+	}
+	go storage.run()
+
+	return
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Starts a Bulk API stand-in responding to requests in turn with the status of each document, or
+// with a single status for the request as a whole. Responds successfully for all documents once
+// out of responses. Returns the documents of each request.
+func testElasticsearchServer(
+	t *testing.T,
+	responses ...[]int,
+) (server *httptest.Server, requests func() [][]string) {
+	var (
+		mutex    sync.Mutex // Mutex for managing requests.
+		received [][]string // Documents of each request.
+	)
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.URL.Path != "/fizz/_bulk" {
+			t.Errorf("Got: %v Expected: %v\n", r.URL.Path, "/fizz/_bulk")
+		}
+		var documents []string // Documents of the request.
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 1 {
+				documents = append(documents, scanner.Text())
+			}
+		}
+		received = append(received, documents)
+
+		// The client only accepts responses from Elasticsearch.
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+		statuses := make([]int, len(documents))
+		for i := range statuses {
+			statuses[i] = http.StatusCreated
+		}
+		if len(responses) > 0 {
+			statuses, responses = responses[0], responses[1:]
+		}
+		if len(statuses) == 1 && len(documents) != 1 {
+			w.WriteHeader(statuses[0])
+			fmt.Fprint(w, `{"error":"nope"}`)
+			return
+		}
+
+		items := make([]map[string]interface{}, len(statuses))
+		for i, status := range statuses {
+			item := map[string]interface{}{"status": status}
+			if status >= 300 {
+				item["error"] = map[string]string{"type": fmt.Sprintf("error_%d", status)}
+			}
+			items[i] = map[string]interface{}{"index": item}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+	}))
+
+	return server, func() [][]string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received
+	}
+}
+
+// Creates an Elasticsearch storage with a dead-letter file in a directory, retrying quickly.
+func testElasticsearchStorage(t *testing.T, address, dir string) *ElasticsearchStorage {
+	storage, err := newElasticsearchStorage(address, "fizz", "", "", filepath.Join(dir, "dead"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(*storage).backoff = time.Millisecond
+
+	return storage
+}
+
+// Reads the entries of a dead-letter file.
+func testDeadLetters(t *testing.T, path string) (entries []map[string]interface{}) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{} // Entry of a document.
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+func TestElasticsearchStoragePut(t *testing.T) {
+	server, requests := testElasticsearchServer(t)
+	defer server.Close()
+
+	storage := testElasticsearchStorage(t, server.URL, t.TempDir())
+	for i := 0; i < 3; i++ {
+		storage.Put("foo", []string{"bar"}, Result{Time: testTime(), Values: Values{int64(i)}})
+	}
+
+	// It buffers documents until flushed.
+	if got := storage.Stats(); got != (ElasticsearchStats{Pending: 3}) {
+		t.Errorf("Got: %v Expected: %v\n", got, ElasticsearchStats{Pending: 3})
+	}
+
+	// It indexes buffered documents in a single request.
+	if storage.flush() {
+		t.Errorf("Got: %v Expected: %v\n", true, false)
+	}
+	if got := requests(); len(got) != 1 || len(got[0]) != 3 ||
+		!strings.Contains(got[0][2], `"shui.value.bar":2`) {
+		t.Errorf("Got: %v Expected: %v\n", got, "a request with three documents")
+	}
+	if got := storage.Stats(); got != (ElasticsearchStats{Indexed: 3}) {
+		t.Errorf("Got: %v Expected: %v\n", got, ElasticsearchStats{Indexed: 3})
+	}
+}
+
+func TestElasticsearchStorageRetry(t *testing.T) {
+	server, requests := testElasticsearchServer(t,
+		[]int{http.StatusTooManyRequests},
+		[]int{http.StatusCreated, http.StatusServiceUnavailable, http.StatusBadRequest},
+	)
+	defer server.Close()
+
+	dir := t.TempDir()
+	storage := testElasticsearchStorage(t, server.URL, dir)
+	for i := 0; i < 3; i++ {
+		storage.Put("foo", []string{"bar"}, Result{Time: testTime(), Values: Values{int64(i)}})
+	}
+	storage.flush()
+
+	// It retries requests and documents that may succeed later.
+	if got := requests(); len(got) != 3 || len(got[2]) != 1 ||
+		!strings.Contains(got[2][0], `"shui.value.bar":1`) {
+		t.Errorf("Got: %v Expected: %v\n", got, "a retry of the second document")
+	}
+	expected := ElasticsearchStats{Failed: 1, Indexed: 2, Retried: 4}
+	if got := storage.Stats(); got != expected {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It writes rejected documents to the dead-letter file.
+	entries := testDeadLetters(t, filepath.Join(dir, "dead"))
+	if len(entries) != 1 ||
+		entries[0]["document"].(map[string]interface{})["shui.value.bar"] != float64(2) ||
+		!strings.Contains(entries[0]["reason"].(string), "error_400") {
+		t.Errorf("Got: %v Expected: %v\n", entries, "the rejected document")
+	}
+}
+
+func TestElasticsearchStorageDeadLetter(t *testing.T) {
+	var responses [][]int // Responses for every attempt.
+	for i := 0; i <= ELASTICSEARCH_MAX_RETRIES; i++ {
+		responses = append(responses, []int{http.StatusBadGateway})
+	}
+	server, requests := testElasticsearchServer(t, responses...)
+	defer server.Close()
+
+	dir := t.TempDir()
+	storage := testElasticsearchStorage(t, server.URL, dir)
+	for i := 0; i < 2; i++ {
+		storage.Put("foo", []string{"bar"}, Result{Time: testTime(), Values: Values{int64(i)}})
+	}
+	storage.flush()
+
+	// It gives up on documents once out of retries.
+	if got := len(requests()); got != ELASTICSEARCH_MAX_RETRIES+1 {
+		t.Errorf("Got: %v Expected: %v\n", got, ELASTICSEARCH_MAX_RETRIES+1)
+	}
+	expected := ElasticsearchStats{Failed: 2, Retried: 2 * ELASTICSEARCH_MAX_RETRIES}
+	if got := storage.Stats(); got != expected {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It refuses documents once the buffer is full.
+	for i := 0; i < ELASTICSEARCH_MAX_BUFFER; i++ {
+		storage.Put("foo", []string{"bar"}, Result{Time: testTime(), Values: Values{int64(i)}})
+	}
+	if err := storage.Put("foo", []string{}, Result{Time: testTime()}); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "an error")
+	}
+	if got := len(testDeadLetters(t, filepath.Join(dir, "dead"))); got != 3 {
+		t.Errorf("Got: %v Expected: %v\n", got, 3)
+	}
+}
@@ -4,17 +4,18 @@
 package storage
 
 import (
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus/push"
@@ -40,69 +41,54 @@ var (
 	normalize_regexp = regexp.MustCompile("[^a-zA-Z0-9_]+")
 )
 
-// Interface for any external storage system.
-type externalStorage interface {
-	// Add a result to the external storage.
-	Put(query string, labels []string, result Result) error
-}
+// Represents a type of Prometheus metric.
+type MetricType int
 
-////////////////////////////////////////////////////////////////////////////////////////////////////
-//
-// ElasticsearchStorage
-//
-////////////////////////////////////////////////////////////////////////////////////////////////////
-
-type ElasticsearchStorage struct {
-	address  string // Address to connect to Elasticsearch.
-	index    string // Index to supply documents to.
-	user     string // Username for HTTP Baisc Auth.
-	password string // Password for HTTP Baisc Auth.
+// Fetches a common name from a metric type value.
+func (t MetricType) String() string {
+	return MetricTypes[t]
 }
 
-func (e *ElasticsearchStorage) Put(query string, labels []string, result Result) error {
-	var (
-		es      *elasticsearch.Client // Client for querying Elasticsearch.
-		payload []byte                // Query payload to turn results into documents.
-
-		// Configuration for the Elasticsearch client.
-		esConfig = elasticsearch.Config{
-			Addresses: []string{e.address},
-			Password:  e.password,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
-			Username: e.user,
-		}
-	)
-
-	// Initialize an Elasticsearch client.
-	es, err := elasticsearch.NewClient(esConfig)
-	if err != nil {
-		return err
-	}
+// Metric type constants.
+const (
+	METRIC_TYPE_GAUGE     MetricType = iota // Values as they are. First to serve as the 'default.'
+	METRIC_TYPE_COUNTER                     // Values that only increase, aside from resets.
+	METRIC_TYPE_HISTOGRAM                   // Distribution of values, in buckets.
+	METRIC_TYPE_SUMMARY                     // Distribution of values, in quantiles.
+)
 
-	// Build the document body.
-	payload, err = resultToElasticsearchDocument(query, labels, result)
-	if err != nil {
-		return err
+var (
+	DEFAULT_METRIC_QUANTILES = []float64{0.5, 0.9, 0.99} // Quantiles of summaries, unless configured.
+
+	// Mapping of metric types to their names.
+	MetricTypes = map[MetricType]string{
+		METRIC_TYPE_GAUGE:     "gauge",
+		METRIC_TYPE_COUNTER:   "counter",
+		METRIC_TYPE_HISTOGRAM: "histogram",
+		METRIC_TYPE_SUMMARY:   "summary",
 	}
 
-	slog.Debug("Pushing to Elasticsearch", "result", result)
-	es.Index(e.index, bytes.NewReader(payload))
+	metricLabelRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")   // Valid label names.
+	metricNameRegexp  = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$") // Valid metric names.
+)
 
-	return nil
+// Configuration of the Prometheus metric of a query's values. A configuration without a label
+// applies to every value without a configuration of its own, while others apply to values of their
+// label, presented as a metric of their own.
+type MetricConfig struct {
+	Buckets     []float64         `mapstructure:"buckets"`      // Upper bounds of histogram buckets.
+	ConstLabels map[string]string `mapstructure:"const-labels"` // Labels added to every series.
+	Help        string            `mapstructure:"help"`         // Help text of the metric.
+	Label       string            `mapstructure:"label"`        // Label of the values configured.
+	Name        string            `mapstructure:"name"`         // Name of the metric, as is.
+	Quantiles   []float64         `mapstructure:"quantiles"`    // Quantiles of summaries.
+	Type        string            `mapstructure:"type"`         // Type of the metric.
 }
 
-// Creates a new storage for Elasticsearch.
-func NewElasticsearchStorage(address, index, password, user string) ElasticsearchStorage {
-	return ElasticsearchStorage{
-		address:  address,
-		index:    index,
-		password: password,
-		user:     user,
-	}
+// Interface for any external storage system.
+type externalStorage interface {
+	// Add a result to the external storage.
+	Put(query string, labels []string, result Result) error
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -113,18 +99,15 @@ func NewElasticsearchStorage(address, index, password, user string) Elasticsearc
 
 // Prometheus Pushgateway specific external storage system.
 type PushgatewayStorage struct {
-	address  string               // Address to connect to Pushgateway.
-	registry *prometheus.Registry // Prometheus registry to use.
+	address string      // Address to connect to Pushgateway.
+	metrics promMetrics // Metrics of results to push.
 }
 
 // Add a result to Prometheus Pushgtateway.
 func (p *PushgatewayStorage) Put(query string, labels []string, result Result) error {
 	var (
-		err      error                // General error holder.
-		instance string               // Prometheus instance value.
-		metric   *prometheus.GaugeVec // Produced metric to push.
-
-		name = normalizeString(query) // Name for the metric.
+		err      error  // General error holder.
+		instance string // Prometheus instance value.
 	)
 
 	// Get the instance value.
@@ -133,24 +116,26 @@ func (p *PushgatewayStorage) Put(query string, labels []string, result Result) e
 		return err
 	}
 
-	// Build the metric.
-	metric, err = resultToPromMetric(name, labels, result)
-	if err != nil {
-		return err
-	}
-	(*p).registry.Register(metric)
+	// Metrics that could be updated are pushed, even if some values couldn't be.
+	err = (*p).metrics.put(query, labels, result)
 
-	slog.Debug("Pushing to Pushgtateway", "name", name, "result", result)
-	push.New((*p).address, PROMETHEUS_JOB).Grouping("instance", instance).Gatherer((*p).registry).Push()
+	slog.Debug("Pushing to Pushgtateway", "query", query, "result", result)
+	pushErr := push.New((*p).address, PROMETHEUS_JOB).
+		Grouping("instance", instance).
+		Gatherer((*p).metrics.registry).
+		Push()
+	if err == nil {
+		err = pushErr
+	}
 
-	return nil
+	return err
 }
 
-// Create a new storage for Pushgateway.
-func NewPushgatewayStorage(address string) PushgatewayStorage {
+// Create a new storage for Pushgateway, with the configuration of the metrics of each query.
+func NewPushgatewayStorage(address string, metrics map[string][]MetricConfig) PushgatewayStorage {
 	return PushgatewayStorage{
-		address:  address,
-		registry: prometheus.NewRegistry(),
+		address: address,
+		metrics: newPromMetrics(prometheus.NewRegistry(), metrics),
 	}
 }
 
@@ -161,41 +146,295 @@ func NewPushgatewayStorage(address string) PushgatewayStorage {
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
 type PrometheusStorage struct {
-	registry *prometheus.Registry // Prometheus registry to use.
+	metrics promMetrics // Metrics of results to present.
 }
 
-// Register a result in a Prometheus registry.
+// Update the metrics of a query with a result.
 func (p *PrometheusStorage) Put(query string, labels []string, result Result) error {
+	slog.Debug("Updating Prometheus metrics", "query", query, "result", result)
+
+	return (*p).metrics.put(query, labels, result)
+}
+
+// Create a new storage for Prometheus, with the configuration of the metrics of each query.
+func NewPrometheusStorage(address string, metrics map[string][]MetricConfig) PrometheusStorage {
+	var registry = prometheus.NewRegistry()
+
+	// Start the metrics endpoint for results.
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
+	go http.ListenAndServe(address, nil)
+
+	return PrometheusStorage{
+		metrics: newPromMetrics(registry, metrics),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+//
+// promMetrics
+//
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Metric of values of a query, of one of the Prometheus metric types.
+type promFamily struct {
+	config     MetricConfig       // How the metric is presented.
+	labels     []string           // Labels of the values last observed.
+	last       map[string]float64 // Last values of each label, for counters.
+	metricType MetricType         // Type of the metric.
+	vec        promVec            // The metric itself, with a series for each label.
+}
+
+// Prometheus metrics of a query, created once and updated with each of its results.
+type promQueryMetrics struct {
+	configs  []MetricConfig         // Configuration of the query's metrics.
+	duration prometheus.Gauge       // Query execution time.
+	exitCode prometheus.Gauge       // Query exit code.
+	families map[string]*promFamily // Metrics of values, by the label they're configured for.
+	name     string                 // Name for the query's metrics.
+}
+
+// Prometheus metrics of results, shared by Prometheus integrations. Metrics are registered once
+// for each query, since registering them again fails and would leave the first values in place.
+type promMetrics struct {
+	configs  map[string][]MetricConfig    // Configuration of metrics, by query.
+	mutex    *sync.Mutex                  // Mutex for managing metrics.
+	queries  map[string]*promQueryMetrics // Metrics of each query, by query.
+	registry *prometheus.Registry         // Registry metrics are registered with.
+}
+
+// Metric vectors of any type, with a series for each label.
+type promVec interface {
+	prometheus.Collector
+	DeleteLabelValues(lvs ...string) bool
+}
+
+// Creates metrics registered with a registry, configured for each query.
+func newPromMetrics(registry *prometheus.Registry, configs map[string][]MetricConfig) promMetrics {
+	return promMetrics{
+		configs:  configs,
+		mutex:    &sync.Mutex{},
+		queries:  make(map[string]*promQueryMetrics),
+		registry: registry,
+	}
+}
+
+// Builds a metric vector of a type. Configuration is expected to have been validated.
+func newPromVec(metricType MetricType, name, help string, config MetricConfig) promVec {
 	var (
-		err    error                // General error holder.
-		metric *prometheus.GaugeVec // Produced metric to push.
+		variableLabels = []string{PROMETHEUS_METRIC_LABEL} // Labels of series.
+	)
+
+	switch metricType {
+	case METRIC_TYPE_COUNTER:
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name, Help: help, ConstLabels: config.ConstLabels,
+		}, variableLabels)
+	case METRIC_TYPE_HISTOGRAM:
+		buckets := config.Buckets
+		if len(buckets) == 0 {
+			buckets = prometheus.DefBuckets
+		}
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: name, Help: help, ConstLabels: config.ConstLabels, Buckets: buckets,
+		}, variableLabels)
+	case METRIC_TYPE_SUMMARY:
+		quantiles := config.Quantiles
+		if len(quantiles) == 0 {
+			quantiles = DEFAULT_METRIC_QUANTILES
+		}
+		objectives := make(map[float64]float64, len(quantiles))
+		for _, quantile := range quantiles {
+			// Closer to the tails, quantiles need to be more precise.
+			objectives[quantile] = (1 - quantile) / 10
+		}
+		return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name: name, Help: help, ConstLabels: config.ConstLabels, Objectives: objectives,
+		}, variableLabels)
+	default:
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: name, Help: help, ConstLabels: config.ConstLabels,
+		}, variableLabels)
+	}
+}
+
+// Registers collectors, unregistering them all again if any fail so that later results may retry.
+func (p *promMetrics) register(collectors ...prometheus.Collector) (err error) {
+	for i, collector := range collectors {
+		if err = (*p).registry.Register(collector); err != nil {
+			for _, registered := range collectors[:i] {
+				(*p).registry.Unregister(registered)
+			}
+			return
+		}
+	}
+
+	return
+}
+
+// Provides the metrics of a query, creating and registering its metadata metrics the first time.
+func (p *promMetrics) queryMetrics(query string) (metrics *promQueryMetrics, err error) {
+	var (
+		ok bool // Whether the metrics already exist.
 
-		name = normalizeString(query) // Name for the metric.
+		configs = (*p).configs[query]    // Configuration of the query's metrics.
+		name    = normalizeString(query) // Name for the metrics.
 	)
 
-	// Build the metric.
-	metric, err = resultToPromMetric(name, labels, result)
-	if err != nil {
-		return err
+	if metrics, ok = (*p).queries[query]; ok {
+		return
+	}
+
+	// Metadata metrics share the constant labels of the query's metrics.
+	constLabels := metricConfigFor(configs, "").ConstLabels
+	metrics = &promQueryMetrics{
+		configs: configs,
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        fmt.Sprintf("%s_%s_duration_seconds", PROMETHEUS_METRIC_PREFIX, name),
+			Help:        PROMETHEUS_METRICS_HELP,
+			ConstLabels: constLabels,
+		}),
+		exitCode: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        fmt.Sprintf("%s_%s_exit_code", PROMETHEUS_METRIC_PREFIX, name),
+			Help:        PROMETHEUS_METRICS_HELP,
+			ConstLabels: constLabels,
+		}),
+		families: make(map[string]*promFamily),
+		name:     name,
+	}
+	if err = p.register((*metrics).duration, (*metrics).exitCode); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to register metrics for %s: %v", name, err))
+	}
+	(*p).queries[query] = metrics
+
+	return
+}
+
+// Provides the metric that values of a label are observed in, creating and registering it the
+// first time. Labels without a configuration of their own share the query's metric.
+func (p *promMetrics) family(
+	metrics *promQueryMetrics,
+	label string,
+) (family *promFamily, err error) {
+	var (
+		ok bool // Whether the metric already exists.
+
+		config = metricConfigFor((*metrics).configs, label) // Configuration of the metric.
+		help   = PROMETHEUS_METRICS_HELP                    // Help text of the metric.
+		name   = promMetricName((*metrics).name, config)    // Name of the metric.
+	)
+
+	if family, ok = (*metrics).families[config.Label]; ok {
+		return
+	}
+
+	if config.Help != "" {
+		help = config.Help
+	}
+	metricType, _ := MetricTypeFromString(config.Type)
+	family = &promFamily{
+		config:     config,
+		last:       make(map[string]float64),
+		metricType: metricType,
+		vec:        newPromVec(metricType, name, help, config),
+	}
+	if err = p.register((*family).vec); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to register metric %s: %v", name, err))
 	}
-	(*p).registry.Register(metric)
+	(*metrics).families[config.Label] = family
 
-	slog.Debug("Pushing to Prometheus", "name", name, "result", result)
+	return
+}
+
+// Observes a value of a label. Counters follow values that only increase, treating any decrease as
+// the value having reset to zero.
+func (f *promFamily) observe(label string, value float64) error {
+	switch (*f).metricType {
+	case METRIC_TYPE_COUNTER:
+		if value < 0 {
+			return errors.New(fmt.Sprintf("Counters can't be negative, Value: %v", value))
+		}
+		last, ok := (*f).last[label]
+		(*f).last[label] = value
+		if ok && value >= last {
+			value -= last
+		}
+		// Otherwise, the value is new or was reset, counting from zero.
+		(*f).vec.(*prometheus.CounterVec).WithLabelValues(label).Add(value)
+	case METRIC_TYPE_HISTOGRAM:
+		(*f).vec.(*prometheus.HistogramVec).WithLabelValues(label).Observe(value)
+	case METRIC_TYPE_SUMMARY:
+		(*f).vec.(*prometheus.SummaryVec).WithLabelValues(label).Observe(value)
+	default:
+		(*f).vec.(*prometheus.GaugeVec).WithLabelValues(label).Set(value)
+	}
 
 	return nil
 }
 
-// Create a new storage for Prometheus.
-func NewPrometheusStorage(address string) PrometheusStorage {
-	var registry = prometheus.NewRegistry()
+// Updates the metrics of a query with a result. Values of labels that a result no longer has are
+// removed, rather than presenting stale values. Values that aren't numbers are skipped, and are
+// reported once the rest are updated. Results that didn't complete only update execution metadata.
+func (p *promMetrics) put(query string, labels []string, result Result) (err error) {
+	var (
+		family  *promFamily       // Metric of a value.
+		metrics *promQueryMetrics // Metrics of the query.
+		skipErr error             // Error of the last value skipped.
 
-	// Start the metrics endpoint for results.
-	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
-	go http.ListenAndServe(address, nil)
+		current = make(map[*promFamily][]string) // Labels observed in each metric.
+	)
 
-	return PrometheusStorage{
-		registry: registry,
+	(*p).mutex.Lock()
+	defer (*p).mutex.Unlock()
+
+	if metrics, err = p.queryMetrics(query); err != nil {
+		return
+	}
+	(*metrics).duration.Set(result.Duration.Seconds())
+	(*metrics).exitCode.Set(float64(result.ExitCode))
+	if result.Status != RESULT_STATUS_OK {
+		return
+	}
+
+	for i, value := range result.Values {
+		label := strconv.Itoa(i)
+		if i < len(labels) {
+			label = labels[i]
+		}
+
+		var number float64 // Value as a number.
+		switch value.(type) {
+		case int64:
+			number = float64(value.(int64))
+		case float64:
+			number = value.(float64)
+		default:
+			// We encountered a value Prometheus can't digest.
+			skipErr = &NaNError{Value: value}
+			continue
+		}
+
+		if family, err = p.family(metrics, label); err != nil {
+			return
+		}
+		if observeErr := family.observe(label, number); observeErr != nil {
+			skipErr = observeErr
+			continue
+		}
+		current[family] = append(current[family], label)
+	}
+
+	// Remove series of labels that are no longer present.
+	for _, family := range (*metrics).families {
+		for _, label := range (*family).labels {
+			if !slices.Contains(current[family], label) {
+				(*family).vec.DeleteLabelValues(label)
+				delete((*family).last, label)
+			}
+		}
+		(*family).labels = current[family]
 	}
+
+	return skipErr
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -228,6 +467,36 @@ func getPromInstance() (localIP string, err error) {
 	return localIP, err
 }
 
+// Finds the configuration of the metric of a label's values, falling back to that of the query.
+func metricConfigFor(configs []MetricConfig, label string) MetricConfig {
+	var (
+		fallback MetricConfig // Configuration applying to the query.
+	)
+
+	for _, config := range configs {
+		switch config.Label {
+		case label:
+			return config
+		case "":
+			fallback = config
+		}
+	}
+
+	return fallback
+}
+
+// Names the metric of values with a configuration, given the name of the query's metrics.
+func promMetricName(name string, config MetricConfig) string {
+	switch {
+	case config.Name != "":
+		return config.Name
+	case config.Label != "":
+		return fmt.Sprintf("%s_%s_%s", PROMETHEUS_METRIC_PREFIX, name, normalizeString(config.Label))
+	default:
+		return fmt.Sprintf("%s_%s", PROMETHEUS_METRIC_PREFIX, name)
+	}
+}
+
 // Converts a string to something acceptable as a name or label useable by external sources.
 func normalizeString(s string) string {
 	// The operations are:
@@ -247,8 +516,8 @@ func resultToElasticsearchDocument(
 	labels []string,
 	result Result,
 ) (document []byte, err error) {
-	// Payload to construct the document from, accounting for the two additional fields added.
-	var payload = make(map[string]interface{}, len(labels)+2)
+	// Payload to construct the document from, accounting for the additional fields added.
+	var payload = make(map[string]interface{}, len(labels)+6)
 
 	// Add fields for each value.
 	for k, v := range result.Map(labels) {
@@ -257,7 +526,11 @@ func resultToElasticsearchDocument(
 
 	// Add additional fields to the payload.
 	payload["timestamp"] = result.Time
+	payload["shui.duration"] = result.Duration.Seconds()
+	payload["shui.exit_code"] = result.ExitCode
 	payload["shui.query"] = query
+	payload["shui.status"] = result.Status.String()
+	payload["shui.stderr"] = result.Stderr
 
 	// Build the document body.
 	document, err = json.Marshal(payload)
@@ -265,46 +538,61 @@ func resultToElasticsearchDocument(
 	return
 }
 
-// Converts a result to a Prometheus metric.
-func resultToPromMetric(
-	name string,
-	labels []string,
-	result Result,
-) (metric *prometheus.GaugeVec, err error) {
-	metric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: fmt.Sprintf("%s_%s", PROMETHEUS_METRIC_PREFIX, name),
-			Help: PROMETHEUS_METRICS_HELP,
-		},
-		[]string{PROMETHEUS_METRIC_LABEL},
-	)
+////////////////////////////////////////////////////////////////////////////////////////////////////
+//
+// Public Functions
+//
+////////////////////////////////////////////////////////////////////////////////////////////////////
 
-	for i, value := range result.Values {
-		switch value.(type) {
-		case int64:
-			metric.With(prometheus.Labels{
-				PROMETHEUS_METRIC_LABEL: labels[i],
-			}).Set(float64(value.(int64)))
-		case float64:
-			metric.With(prometheus.Labels{
-				PROMETHEUS_METRIC_LABEL: labels[i],
-			}).Set(value.(float64))
-		default:
-			// We encountered a value Prometheus can't digest.
-			err = &NaNError{Value: value}
+// Fetches a metric type from its name. Metrics are gauges unless given a type.
+func MetricTypeFromString(s string) (MetricType, error) {
+	if s == "" {
+		return METRIC_TYPE_GAUGE, nil
+	}
+	for k, v := range MetricTypes {
+		if s == v {
+			return k, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Unknown metric type %s", s))
+}
+
+// Checks that the metrics of a query may be presented.
+func ValidateMetrics(configs []MetricConfig) (err error) {
+	var (
+		labels []string // Labels configured so far.
+	)
 
-			// TODO For now, we give-up if any value is non-pushable. In the future, we might consider
-			// still attempting to push some values, but this would also require better error handling in
-			// `Put`.
-			break
+	for _, config := range configs {
+		if _, err = MetricTypeFromString(config.Type); err != nil {
+			return
+		}
+		switch {
+		case slices.Contains(labels, config.Label):
+			return errors.New(fmt.Sprintf("Metric of label %q is configured twice", config.Label))
+		case config.Name != "" && !metricNameRegexp.MatchString(config.Name):
+			return errors.New(fmt.Sprintf("Invalid metric name %s", config.Name))
+		}
+		for i := 1; i < len(config.Buckets); i++ {
+			if config.Buckets[i] <= config.Buckets[i-1] {
+				return errors.New(fmt.Sprintf("Metric buckets must increase: %v", config.Buckets))
+			}
 		}
+		for name := range config.ConstLabels {
+			// Some labels are already used by metrics of some types.
+			if !metricLabelRegexp.MatchString(name) ||
+				slices.Contains([]string{PROMETHEUS_METRIC_LABEL, "le", "quantile"}, name) {
+				return errors.New(fmt.Sprintf("Invalid metric label %s", name))
+			}
+		}
+		for _, quantile := range config.Quantiles {
+			if quantile <= 0 || quantile >= 1 {
+				return errors.New(fmt.Sprintf("Metric quantiles must be between 0 and 1: %v", quantile))
+			}
+		}
+		labels = append(labels, config.Label)
 	}
 
 	return
 }
-
-////////////////////////////////////////////////////////////////////////////////////////////////////
-//
-// Public Functions
-//
-////////////////////////////////////////////////////////////////////////////////////////////////////
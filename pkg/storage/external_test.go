@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestNormalizeString(t *testing.T) {
@@ -18,3 +23,208 @@ func TestNormalizeString(t *testing.T) {
 		}
 	}
 }
+
+func TestResultToElasticsearchDocument(t *testing.T) {
+	var got map[string]interface{}
+
+	result := Result{
+		Time:     testTime(),
+		Value:    "1 2",
+		Values:   Values{int64(1), int64(2)},
+		Duration: 1500 * time.Millisecond,
+		ExitCode: 1,
+		Stderr:   "foo",
+	}
+
+	document, err := resultToElasticsearchDocument("fizz", []string{"foo", "bar"}, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(document, &got)
+
+	// It builds a document with values and result metadata.
+	expected := map[string]interface{}{
+		"shui.duration":  1.5,
+		"shui.exit_code": float64(1),
+		"shui.query":     "fizz",
+		"shui.status":    "ok",
+		"shui.stderr":    "foo",
+		"shui.value.bar": float64(2),
+		"shui.value.foo": float64(1),
+		"timestamp":      testTime().Format(time.RFC3339Nano),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+// Gathers the values of a metric from a registry, by label. Histograms and summaries provide their
+// number of observations.
+func gatherPromValues(t *testing.T, registry *prometheus.Registry, name string) map[string]float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			label := ""
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == PROMETHEUS_METRIC_LABEL {
+					label = pair.GetValue()
+				}
+			}
+			switch {
+			case metric.Counter != nil:
+				values[label] = metric.GetCounter().GetValue()
+			case metric.Histogram != nil:
+				values[label] = float64(metric.GetHistogram().GetSampleCount())
+			case metric.Summary != nil:
+				values[label] = float64(metric.GetSummary().GetSampleCount())
+			default:
+				values[label] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+
+	return values
+}
+
+func TestPrometheusStoragePut(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := PrometheusStorage{metrics: newPromMetrics(registry, nil)}
+
+	// It presents the values of a result.
+	err := p.Put("foo", []string{"bar", "fizz"}, Result{Values: Values{int64(1), 2.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"bar": 1, "fizz": 2.5}
+	if got := gatherPromValues(t, registry, "shui_foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It updates values with later results, removing labels no longer present.
+	err = p.Put(
+		"foo", []string{"bar", "buzz"}, Result{Values: Values{int64(3), int64(4)}, ExitCode: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]float64{"bar": 3, "buzz": 4}
+	if got := gatherPromValues(t, registry, "shui_foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+	expected = map[string]float64{"": 2}
+	if got := gatherPromValues(t, registry, "shui_foo_exit_code"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It updates numbers even when other values aren't.
+	err = p.Put("foo", []string{"bar", "buzz"}, Result{Values: Values{int64(5), "fizz"}})
+	if _, ok := err.(*NaNError); !ok {
+		t.Errorf("Got: %v Expected: %v\n", err, &NaNError{Value: "fizz"})
+	}
+	expected = map[string]float64{"bar": 5}
+	if got := gatherPromValues(t, registry, "shui_foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It surfaces metrics that fail to register.
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "shui_bar_exit_code"}))
+	if err = p.Put("bar", []string{}, Result{Values: Values{int64(1)}}); err == nil {
+		t.Errorf("Got: %v Expected: an error\n", err)
+	}
+	if got := gatherPromValues(t, registry, "shui_bar_duration_seconds"); len(got) != 0 {
+		t.Errorf("Got: %v Expected: %v\n", got, map[string]float64{})
+	}
+}
+
+func TestPrometheusStorageTypes(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := PrometheusStorage{metrics: newPromMetrics(registry, map[string][]MetricConfig{
+		"foo": {
+			{ConstLabels: map[string]string{"host": "fizz"}, Help: "Foo.", Type: "counter"},
+			{Buckets: []float64{1, 10}, Label: "bar", Name: "bar_seconds", Type: "histogram"},
+			{Label: "buzz", Type: "summary"},
+		},
+	})}
+
+	for _, values := range []Values{{int64(5), 0.5, int64(1)}, {int64(8), 2.5, int64(2)}} {
+		if err := p.Put("foo", []string{"fizz", "bar", "buzz"}, Result{Values: values}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// It counts increases of values.
+	expected := map[string]float64{"fizz": 8}
+	if got := gatherPromValues(t, registry, "shui_foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It observes values of labels in metrics of their own.
+	expected = map[string]float64{"bar": 2}
+	if got := gatherPromValues(t, registry, "bar_seconds"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+	expected = map[string]float64{"buzz": 2}
+	if got := gatherPromValues(t, registry, "shui_foo_buzz"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It counts from zero again when counters reset.
+	if err := p.Put("foo", []string{"fizz"}, Result{Values: Values{int64(3)}}); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]float64{"fizz": 11}
+	if got := gatherPromValues(t, registry, "shui_foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It applies help text and constant labels.
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "shui_foo" {
+			continue
+		}
+		if got := family.GetHelp(); got != "Foo." {
+			t.Errorf("Got: %v Expected: %v\n", got, "Foo.")
+		}
+		if got := family.GetMetric()[0].GetLabel()[0]; got.GetName() != "host" ||
+			got.GetValue() != "fizz" {
+			t.Errorf("Got: %v Expected: %v\n", got, "host=fizz")
+		}
+	}
+}
+
+func TestValidateMetrics(t *testing.T) {
+	// It accepts metrics that may be presented.
+	configs := []MetricConfig{
+		{ConstLabels: map[string]string{"host": "foo"}, Name: "foo_total", Type: "counter"},
+		{Buckets: []float64{1, 2}, Label: "bar", Type: "histogram"},
+		{Label: "fizz", Quantiles: []float64{0.5}, Type: "summary"},
+	}
+	if err := ValidateMetrics(configs); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+
+	// It rejects metrics that may not.
+	for _, configs := range [][]MetricConfig{
+		{{Type: "foo"}},
+		{{Label: "foo"}, {Label: "foo"}},
+		{{Name: "foo-bar"}},
+		{{Buckets: []float64{2, 1}, Type: "histogram"}},
+		{{ConstLabels: map[string]string{"le": "foo"}, Type: "histogram"}},
+		{{Quantiles: []float64{1}, Type: "summary"}},
+	} {
+		if err := ValidateMetrics(configs); err == nil {
+			t.Errorf("Got: %v Expected: an error\n", err)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+//
+// Persistence of storage to disk.
+//
+// Results are persisted to an append-only log of length-prefixed, checksummed records, one per
+// result. Periodically, all results are compacted into a snapshot and a new log is started.
+// Snapshots and logs share a generation number, where a snapshot holds everything that came before
+// the log of the same generation. Loading storage means reading the latest snapshot and replaying
+// its log on top of it.
+//
+// Records are laid out as:
+//
+//	[4 byte payload length][4 byte CRC-32 of the payload][JSON payload]
+//
+// A crash may leave a partially written record at the end of a log, which is discarded (and
+// truncated away) when storage is next loaded.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Represents a policy for flushing persisted results to disk.
+type SyncPolicy int
+
+// Fetches a common name from a sync policy value.
+func (p SyncPolicy) String() string {
+	return SyncPolicies[p]
+}
+
+// Sync policy constants.
+const (
+	SYNC_POLICY_PERIODIC SyncPolicy = iota // Sync at most every interval. First to serve as the 'default.'
+	SYNC_POLICY_ALWAYS                     // Sync after every result.
+	SYNC_POLICY_NEVER                      // Leave syncing to the operating system.
+)
+
+const (
+	MAX_RECORD_SIZE         = 64 << 20                // Largest record payload, in bytes.
+	RECORD_HEADER_SIZE      = 8                       // Size of a record header (length and checksum).
+	SNAPSHOT_INTERVAL       = 4096                    // Number of log records that trigger a snapshot.
+	STORAGE_FILE_GLOB       = "storage-*"             // Pattern matching logs and snapshots.
+	STORAGE_LOG_FORMAT      = "storage-%08d.log"      // Filename format for logs, given a generation.
+	STORAGE_MIGRATED_SUFFIX = ".migrated"             // Suffix given to legacy storage once migrated.
+	STORAGE_SNAPSHOT_FORMAT = "storage-%08d.snapshot" // Filename format for snapshots, given a generation.
+	STORAGE_SYNC_INTERVAL   = time.Second             // Interval for periodic syncs.
+)
+
+var (
+	// Mapping of sync policy constants to a common sync policy name.
+	SyncPolicies = map[SyncPolicy]string{
+		SYNC_POLICY_ALWAYS:   "always",
+		SYNC_POLICY_NEVER:    "never",
+		SYNC_POLICY_PERIODIC: "periodic",
+	}
+
+	// Error indicating a record failed its checksum.
+	errRecordChecksum = errors.New("Record checksum mismatch")
+	// Error indicating a record is larger than any record written.
+	errRecordSize = errors.New("Record too large")
+)
+
+// Single persisted change to a results series, either a result or a change in labels.
+type record struct {
+	Query  string       // Query the change belongs to.
+	Labels *LabelSchema `json:",omitempty"` // Persisted labels.
+	Result *Result      `json:",omitempty"` // Persisted result.
+}
+
+// Reads records until the end of a reader, supplying each to a function. Returns the offset
+// following the last complete, valid record. Reaching the end of the reader in the middle of a
+// record or encountering a corrupted record is reported as an error, alongside the offset of valid
+// data.
+func readRecords(r io.Reader, f func(record)) (offset int64, err error) {
+	var (
+		header  = make([]byte, RECORD_HEADER_SIZE) // Record header.
+		payload []byte                             // Record payload.
+		next    record                             // Decoded record.
+	)
+
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				// We've cleanly reached the end.
+				err = nil
+			}
+			return
+		}
+
+		// Lengths are checked before allocating, since a corrupted header may claim anything.
+		if binary.BigEndian.Uint32(header[:4]) > MAX_RECORD_SIZE {
+			err = errRecordSize
+			return
+		}
+		payload = make([]byte, binary.BigEndian.Uint32(header[:4]))
+		if _, err = io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			err = errRecordChecksum
+			return
+		}
+
+		next = record{}
+		if err = json.Unmarshal(payload, &next); err != nil {
+			return
+		}
+
+		f(next)
+		offset += int64(RECORD_HEADER_SIZE + len(payload))
+	}
+}
+
+// Writes a single record.
+func writeRecord(w io.Writer, next record) error {
+	payload, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MAX_RECORD_SIZE {
+		return errRecordSize
+	}
+
+	// Assemble the full record before writing so that it goes out in a single write.
+	buf := make([]byte, RECORD_HEADER_SIZE, RECORD_HEADER_SIZE+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	_, err = w.Write(append(buf, payload...))
+
+	return err
+}
+
+// Syncs a directory, making renames and file creation within it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Lists the generations of persisted files matching a filename format, in ascending order.
+func listGenerations(dir, format string) (generations []int, err error) {
+	var (
+		generation int      // Parsed generation.
+		paths      []string // Paths of candidate files.
+	)
+
+	paths, err = filepath.Glob(filepath.Join(dir, STORAGE_FILE_GLOB))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		// Only consider files that exactly match the format.
+		_, scanErr := fmt.Sscanf(filepath.Base(path), format, &generation)
+		if scanErr == nil && fmt.Sprintf(format, generation) == filepath.Base(path) {
+			generations = append(generations, generation)
+		}
+	}
+	slices.Sort(generations)
+
+	return
+}
+
+// Fetches a sync policy value from its common name.
+func SyncPolicyFromString(s string) (SyncPolicy, error) {
+	for k, v := range SyncPolicies {
+		if s == v {
+			return k, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Unknown sync policy %s", s))
+}
+
+// Adds a record to the current log, syncing according to the sync policy and taking a snapshot if
+// the log has grown large enough. Expects the storage mutex to be held, preventing interleaved
+// writes.
+func (s *Storage) persist(next record) (err error) {
+	if err = writeRecord((*s).logFile, next); err != nil {
+		return
+	}
+	(*s).logRecords++
+
+	switch (*s).syncPolicy {
+	case SYNC_POLICY_ALWAYS:
+		err = (*s).logFile.Sync()
+		(*s).lastSync = time.Now()
+	case SYNC_POLICY_PERIODIC:
+		if time.Since((*s).lastSync) >= STORAGE_SYNC_INTERVAL {
+			err = (*s).logFile.Sync()
+			(*s).lastSync = time.Now()
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	if (*s).logRecords >= SNAPSHOT_INTERVAL {
+		err = s.snapshot()
+	}
+
+	return
+}
+
+// Loads persisted results from the storage directory, migrating legacy storage if necessary, and
+// opens the current log for writing.
+func (s *Storage) load() (err error) {
+	var (
+		logGenerations      []int // Generations of existing logs.
+		snapshotGenerations []int // Generations of existing snapshots.
+
+		legacyPath = filepath.Join((*s).storageDir, STORAGE_LEGACY_FILE_NAME) // Legacy storage file.
+		putRecord  = func(next record) {
+			switch {
+			case next.Labels != nil:
+				s.newResults(next.Query, len(next.Labels.Labels))
+				(*s).Results[next.Query].putLabels(*next.Labels)
+			case next.Result != nil:
+				s.newResults(next.Query, len(next.Result.Values))
+				(*s).Results[next.Query].putResult(*next.Result)
+			}
+		} // Loads a record into storage.
+	)
+
+	if snapshotGenerations, err = listGenerations((*s).storageDir, STORAGE_SNAPSHOT_FORMAT); err != nil {
+		return
+	}
+	if logGenerations, err = listGenerations((*s).storageDir, STORAGE_LOG_FORMAT); err != nil {
+		return
+	}
+
+	// Migrate legacy storage, but only if nothing has been persisted since.
+	if len(snapshotGenerations) == 0 && len(logGenerations) == 0 {
+		if _, statErr := os.Stat(legacyPath); statErr == nil {
+			return s.migrate(legacyPath)
+		}
+	}
+
+	// Load the latest snapshot.
+	if len(snapshotGenerations) > 0 {
+		(*s).generation = snapshotGenerations[len(snapshotGenerations)-1]
+		if err = s.replay(s.snapshotPath((*s).generation), putRecord); err != nil {
+			return
+		}
+	}
+
+	// Replay any logs written since the snapshot. Ordinarily, this is only the log for the snapshot's
+	// generation.
+	for _, logGeneration := range logGenerations {
+		if logGeneration < (*s).generation {
+			continue
+		}
+		(*s).generation = logGeneration
+		if err = s.replay(s.logPath(logGeneration), putRecord); err != nil {
+			return
+		}
+	}
+
+	// Open the current log for further writes.
+	(*s).logFile, err = os.OpenFile(
+		s.logPath((*s).generation),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		fs.FileMode(0660),
+	)
+	if err != nil {
+		return
+	}
+
+	// Clean-up anything that's been superseded.
+	s.removeGenerations((*s).generation)
+
+	return
+}
+
+// Provides the path to a log for a generation.
+func (s *Storage) logPath(generation int) string {
+	return filepath.Join((*s).storageDir, fmt.Sprintf(STORAGE_LOG_FORMAT, generation))
+}
+
+// Loads legacy storage, a single JSON document of all results, and persists it as a snapshot.
+func (s *Storage) migrate(legacyPath string) (err error) {
+	var (
+		legacyData    []byte              // Raw legacy storage data.
+		legacyResults map[string]*Results // Legacy results.
+	)
+
+	slog.Info("Migrating storage", "path", legacyPath)
+
+	if legacyData, err = os.ReadFile(legacyPath); err != nil {
+		return
+	}
+	if len(legacyData) > 0 {
+		// Legacy storage was re-written in place and may have trailing data from a prior, larger
+		// write, so only consider the first JSON value.
+		if err = json.NewDecoder(bytes.NewReader(legacyData)).Decode(&legacyResults); err != nil {
+			return
+		}
+	}
+
+	for query, results := range legacyResults {
+		s.newResults(query, len(results.Labels))
+
+		// Legacy storage only kept the latest labels, which are assumed to apply to all results, unless
+		// they were index labels.
+		if !slices.Equal(results.Labels, defaultLabels(len(results.Labels))) {
+			(*s).Results[query].putLabels(LabelSchema{Labels: results.Labels})
+		}
+
+		for _, result := range results.Results {
+			(*s).Results[query].putResult(result)
+		}
+	}
+
+	// Persist what's been loaded and move legacy storage out of the way.
+	(*s).storageMutex.Lock()
+	defer (*s).storageMutex.Unlock()
+	if err = s.snapshot(); err != nil {
+		return
+	}
+
+	return os.Rename(legacyPath, legacyPath+STORAGE_MIGRATED_SUFFIX)
+}
+
+// Removes snapshots and logs of generations prior to the provided one.
+func (s *Storage) removeGenerations(generation int) {
+	for format, pathFunc := range map[string]func(int) string{
+		STORAGE_LOG_FORMAT:      s.logPath,
+		STORAGE_SNAPSHOT_FORMAT: s.snapshotPath,
+	} {
+		generations, err := listGenerations((*s).storageDir, format)
+		if err != nil {
+			slog.Warn("Failed to list storage files", "err", err)
+			continue
+		}
+		for _, oldGeneration := range generations {
+			if oldGeneration < generation {
+				if err = os.Remove(pathFunc(oldGeneration)); err != nil {
+					slog.Warn("Failed to remove storage file", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// Reads all records from a persisted file, truncating any incomplete or corrupt data at its end.
+func (s *Storage) replay(path string, f func(record)) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset, err := readRecords(bufio.NewReader(file), f)
+	if err != nil {
+		// Keep what's valid and discard the rest so that future writes follow valid records.
+		slog.Warn("Recovering storage", "path", path, "offset", offset, "err", err)
+		return file.Truncate(offset)
+	}
+
+	return nil
+}
+
+// Provides the path to a snapshot for a generation.
+func (s *Storage) snapshotPath(generation int) string {
+	return filepath.Join((*s).storageDir, fmt.Sprintf(STORAGE_SNAPSHOT_FORMAT, generation))
+}
+
+// Writes all results to a snapshot of the next generation and starts a new log. Expects the storage
+// mutex to be held.
+func (s *Storage) snapshot() (err error) {
+	var (
+		logFile      *os.File // Log for the next generation.
+		snapshotFile *os.File // Snapshot being written.
+
+		generation   = (*s).generation + 1                             // Next generation.
+		snapshotPath = s.snapshotPath(generation)                      // Final snapshot location.
+		tempPath     = fmt.Sprintf("%s.%d", snapshotPath, os.Getpid()) // Snapshot location while writing.
+	)
+
+	slog.Debug("Taking storage snapshot", "generation", generation)
+
+	// Write the snapshot to a temporary location, so that an incomplete snapshot is never loaded.
+	snapshotFile, err = os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fs.FileMode(0660))
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempPath)
+	w := bufio.NewWriter(snapshotFile)
+	for query, results := range s.views() {
+		for _, schema := range results.LabelSchemas {
+			if err = writeRecord(w, record{Query: query, Labels: &schema}); err != nil {
+				snapshotFile.Close()
+				return
+			}
+		}
+		for _, result := range results.Results {
+			if err = writeRecord(w, record{Query: query, Result: &result}); err != nil {
+				snapshotFile.Close()
+				return
+			}
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = snapshotFile.Sync()
+	}
+	snapshotFile.Close()
+	if err != nil {
+		return
+	}
+
+	// Make the snapshot visible.
+	if err = os.Rename(tempPath, snapshotPath); err != nil {
+		return
+	}
+	if err = syncDir((*s).storageDir); err != nil {
+		return
+	}
+
+	// Start the next log.
+	logFile, err = os.OpenFile(
+		s.logPath(generation),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		fs.FileMode(0660),
+	)
+	if err != nil {
+		return
+	}
+	if (*s).logFile != nil {
+		(*s).logFile.Close()
+	}
+	(*s).generation, (*s).logFile, (*s).logRecords = generation, logFile, 0
+
+	// Everything prior is now redundant.
+	s.removeGenerations(generation)
+
+	return
+}
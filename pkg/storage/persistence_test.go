@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Builds a test storage persisting to a directory.
+func testPersistedStorage(t *testing.T, dir string) Storage {
+	storage, _ := NewStorage(false, SYNC_POLICY_ALWAYS)
+	storage.storageDir = dir
+	if err := storage.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return storage
+}
+
+func TestStoragePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	// It persists results and reloads them.
+	storage := testPersistedStorage(t, dir)
+	storage.Put("foo", "1 2.5 bar", true, int64(1), 2.5, "bar")
+	storage.Put("foo", "3 4.5 fizz", true, int64(3), 4.5, "fizz")
+	storage.Put("bar", "buzz", true, "buzz")
+	storage.Close()
+
+	reloaded := testPersistedStorage(t, dir)
+	defer reloaded.Close()
+	for _, query := range []string{"foo", "bar"} {
+		got, expected := reloaded.GetAll(query), storage.GetAll(query)
+		if len(got) != len(expected) {
+			t.Fatalf("Got: %v Expected: %v\n", got, expected)
+		}
+		for i := range got {
+			// Compare times separately, since monotonic clock readings aren't persisted.
+			if !got[i].Time.Equal(expected[i].Time) || !reflect.DeepEqual(got[i].Values, expected[i].Values) {
+				t.Errorf("Got: %v Expected: %v\n", got[i], expected[i])
+			}
+		}
+	}
+}
+
+func TestStoragePersistenceRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	storage := testPersistedStorage(t, dir)
+	storage.Put("foo", "1", true, int64(1))
+	storage.Put("foo", "2", true, int64(2))
+	storage.Close()
+
+	// Simulate a crash in the middle of writing a record.
+	logPath := storage.logPath(storage.generation)
+	stat, _ := os.Stat(logPath)
+	validSize := stat.Size()
+	logFile, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	logFile.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	logFile.Close()
+
+	// It loads valid records and discards the rest.
+	reloaded := testPersistedStorage(t, dir)
+	if got := reloaded.GetAll("foo"); len(got) != 2 {
+		t.Errorf("Got: %v\n", got)
+	}
+	if stat, _ = os.Stat(logPath); stat.Size() != validSize {
+		t.Errorf("Got: %v Expected: %v\n", stat.Size(), validSize)
+	}
+
+	// It continues writing after valid records.
+	reloaded.Put("foo", "3", true, int64(3))
+	reloaded.Close()
+	reloaded = testPersistedStorage(t, dir)
+	if got := reloaded.GetAll("foo"); len(got) != 3 {
+		t.Errorf("Got: %v\n", got)
+	}
+}
+
+func TestStoragePersistenceRecordSize(t *testing.T) {
+	dir := t.TempDir()
+
+	storage := testPersistedStorage(t, dir)
+	storage.Put("foo", "1", true, int64(1))
+	storage.Close()
+
+	// Simulate a corrupted header claiming a huge record.
+	logPath := storage.logPath(storage.generation)
+	stat, _ := os.Stat(logPath)
+	validSize := stat.Size()
+	logFile, _ := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	logFile.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	logFile.Close()
+
+	// It refuses the record without reading it, and discards it.
+	reloaded := testPersistedStorage(t, dir)
+	defer reloaded.Close()
+	if got := reloaded.GetAll("foo"); len(got) != 1 {
+		t.Errorf("Got: %v\n", got)
+	}
+	if stat, _ = os.Stat(logPath); stat.Size() != validSize {
+		t.Errorf("Got: %v Expected: %v\n", stat.Size(), validSize)
+	}
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	storage := testPersistedStorage(t, dir)
+	storage.Put("foo", "1", true, int64(1))
+	storage.storageMutex.Lock()
+	if err := storage.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	storage.storageMutex.Unlock()
+	storage.Put("foo", "2", true, int64(2))
+	storage.Close()
+
+	// It only keeps the files of the latest generation.
+	paths, _ := filepath.Glob(filepath.Join(dir, STORAGE_FILE_GLOB))
+	expected := []string{storage.logPath(1), storage.snapshotPath(1)}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Got: %v Expected: %v\n", paths, expected)
+	}
+
+	// It loads results from both the snapshot and the log.
+	storage = testPersistedStorage(t, dir)
+	if got := storage.GetAll("foo"); len(got) != 2 {
+		t.Errorf("Got: %v\n", got)
+	}
+}
+
+func TestStorageMigration(t *testing.T) {
+	dir := t.TempDir()
+	legacyPath := filepath.Join(dir, STORAGE_LEGACY_FILE_NAME)
+	resultTime := time.Date(2024, time.June, 10, 17, 40, 29, 0, time.UTC)
+
+	// Legacy storage may have trailing data from prior writes.
+	legacyData, _ := json.Marshal(map[string]*Results{
+		"foo": {Labels: []string{"0"}, Results: []Result{{Time: resultTime, Values: Values{int64(1)}}}},
+		"bar": {Labels: []string{}, Results: []Result{}},
+	})
+	os.WriteFile(legacyPath, append(legacyData, []byte("}]}")...), 0660)
+
+	// It loads legacy results.
+	storage := testPersistedStorage(t, dir)
+	expected := []Result{{Time: resultTime, Values: Values{int64(1)}}}
+	if got := storage.GetAll("foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+	storage.Close()
+
+	// It moves legacy storage aside and loads from the migrated storage afterwards.
+	if _, err := os.Stat(legacyPath + STORAGE_MIGRATED_SUFFIX); err != nil {
+		t.Error(err)
+	}
+	storage = testPersistedStorage(t, dir)
+	if got := storage.GetAll("foo"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+func TestStorageLabelPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	storage := testPersistedStorage(t, dir)
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	storage.Put("foo", "1 2", true, int64(1), int64(2))
+	storage.PutLabels("bar", []string{"fizz"}) // A series without results.
+	storage.Close()
+
+	// It restores labels without them being provided again.
+	storage = testPersistedStorage(t, dir)
+	for query, expected := range map[string][]string{"foo": {"fizz", "buzz"}, "bar": {"fizz"}} {
+		if got := storage.GetLabels(query, []string{}); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Got: %v Expected: %v\n", got, expected)
+		}
+	}
+
+	// It ignores labels that haven't changed.
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	if got := len(storage.Results["foo"].LabelSchemas); got != 1 {
+		t.Errorf("Got: %v Expected: %v\n", got, 1)
+	}
+
+	// It keeps track of labels changing between executions.
+	storage.PutLabels("foo", []string{"buzz", "fizz"})
+	storage.Put("foo", "3 4", true, int64(3), int64(4))
+	storage.Close()
+
+	storage = testPersistedStorage(t, dir)
+	storage.storageMutex.Lock()
+	storage.snapshot() // Labels survive compaction.
+	storage.storageMutex.Unlock()
+	storage.Close()
+
+	storage = testPersistedStorage(t, dir)
+	defer storage.Close()
+	reader := ReaderIndex(1)
+	got := storage.GetToIndex("foo", []string{"fizz"}, &reader)
+	expected := []Values{{int64(1)}, {int64(4)}}
+	for i := range expected {
+		if !reflect.DeepEqual(got[i].Values, expected[i]) {
+			t.Errorf("Got: %v Expected: %v\n", got[i].Values, expected[i])
+		}
+	}
+}
@@ -0,0 +1,419 @@
+//
+// Prometheus remote write integration.
+//
+// Results are encoded as remote write series and appended to a queue on disk before being sent,
+// so that samples not yet accepted by the receiver survive restarts. Queued series are sent in
+// batches as snappy-compressed protobuf, retrying with backoff while the receiver is unavailable.
+// Sent series are only removed from disk once enough have been sent, so a crash may send some of
+// them again.
+//
+// See: https://prometheus.io/docs/concepts/remote_write_spec/
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	REMOTE_WRITE_BATCH_SIZE     = 500                     // Series sent in each request, at most.
+	REMOTE_WRITE_COMPACT_BYTES  = 1 << 20                 // Size of sent series before compacting.
+	REMOTE_WRITE_FLUSH_INTERVAL = 5 * time.Second         // Interval for sending partial batches.
+	REMOTE_WRITE_MAX_BACKOFF    = 30 * time.Second        // Longest delay between retries.
+	REMOTE_WRITE_MAX_QUEUE      = 100000                  // Series queued before refusing more.
+	REMOTE_WRITE_MAX_RETRIES    = 10                      // Retries of a batch before dropping it.
+	REMOTE_WRITE_MIN_BACKOFF    = time.Second             // Delay before the first retry.
+	REMOTE_WRITE_QUEUE_FORMAT   = "remote-write-%s.queue" // Queue filename format, given an address.
+	REMOTE_WRITE_TIMEOUT        = 30 * time.Second        // Time a request may take.
+	REMOTE_WRITE_VERSION        = "0.1.0"                 // Version of the protocol spoken.
+)
+
+// Field numbers of the remote write protobuf messages.
+//
+// See: https://github.com/prometheus/prometheus/blob/main/prompb/types.proto
+const (
+	PROMPB_WRITE_REQUEST_TIMESERIES protowire.Number = 1 // Series of a WriteRequest.
+	PROMPB_TIMESERIES_LABELS        protowire.Number = 1 // Labels of a TimeSeries.
+	PROMPB_TIMESERIES_SAMPLES       protowire.Number = 2 // Samples of a TimeSeries.
+	PROMPB_LABEL_NAME               protowire.Number = 1 // Name of a Label.
+	PROMPB_LABEL_VALUE              protowire.Number = 2 // Value of a Label.
+	PROMPB_SAMPLE_VALUE             protowire.Number = 1 // Value of a Sample.
+	PROMPB_SAMPLE_TIMESTAMP         protowire.Number = 2 // Timestamp of a Sample, in milliseconds.
+)
+
+// Prometheus remote write specific external storage system.
+type RemoteWriteStorage struct {
+	address   string                    // Address of the remote write endpoint.
+	backoff   time.Duration             // Delay before the first retry.
+	client    *http.Client              // Client for requests to the endpoint.
+	configs   map[string][]MetricConfig // Configuration of metrics, by query.
+	flushChan chan bool                 // Signals that a full batch is queued.
+	instance  string                    // Prometheus instance value.
+	mutex     *sync.Mutex               // Mutex for managing the queue.
+	queue     [][]byte                  // Encoded series waiting to be sent, oldest first.
+	queueFile *os.File                  // Queue on disk, appended to as series are queued.
+	queuePath string                    // Path of the queue on disk.
+	sent      int                       // Size of sent series still in the queue on disk.
+}
+
+// Queues series of a result to be sent. Values that aren't numbers are skipped, and are reported
+// once the rest are queued. Results that didn't complete only send execution metadata.
+func (r *RemoteWriteStorage) Put(query string, labels []string, result Result) (err error) {
+	var (
+		records [][]byte // Series of the result, encoded as they are queued.
+		series  [][]byte // Series of the result.
+
+		configs     = (*r).configs[query]                      // Configuration of the metrics.
+		constLabels = metricConfigFor(configs, "").ConstLabels // Labels of metadata series.
+		name        = normalizeString(query)                   // Name for the query's metrics.
+		timestamp   = result.Time.UnixMilli()                  // Time of samples.
+	)
+
+	series = append(series,
+		(*r).series(fmt.Sprintf("%s_%s_duration_seconds", PROMETHEUS_METRIC_PREFIX, name),
+			constLabels, nil, result.Duration.Seconds(), timestamp),
+		(*r).series(fmt.Sprintf("%s_%s_exit_code", PROMETHEUS_METRIC_PREFIX, name),
+			constLabels, nil, float64(result.ExitCode), timestamp),
+	)
+	if result.Status == RESULT_STATUS_OK {
+		for i, value := range result.Values {
+			label := strconv.Itoa(i)
+			if i < len(labels) {
+				label = labels[i]
+			}
+
+			var number float64 // Value as a number.
+			switch value.(type) {
+			case int64:
+				number = float64(value.(int64))
+			case float64:
+				number = value.(float64)
+			default:
+				err = &NaNError{Value: value}
+				continue
+			}
+
+			config := metricConfigFor(configs, label)
+			series = append(series, (*r).series(promMetricName(name, config), config.ConstLabels,
+				map[string]string{PROMETHEUS_METRIC_LABEL: label}, number, timestamp))
+		}
+	}
+
+	// Series are queued as they appear in requests, so that batches are simply joined.
+	for _, s := range series {
+		records = append(records, protowire.AppendBytes(
+			protowire.AppendTag(nil, PROMPB_WRITE_REQUEST_TIMESERIES, protowire.BytesType), s))
+	}
+
+	slog.Debug("Queueing for remote write", "query", query, "result", result)
+	(*r).mutex.Lock()
+	defer (*r).mutex.Unlock()
+
+	// Results are dropped rather than failing, so that other integrations still receive them.
+	if len((*r).queue)+len(records) > REMOTE_WRITE_MAX_QUEUE {
+		slog.Warn("Dropping result, remote write queue is full", "address", (*r).address)
+		return
+	}
+	if _, writeErr := (*r).queueFile.Write(bytes.Join(records, nil)); writeErr != nil {
+		return writeErr
+	}
+	(*r).queue = append((*r).queue, records...)
+	if len((*r).queue) >= REMOTE_WRITE_BATCH_SIZE {
+		select {
+		case (*r).flushChan <- true:
+		default:
+			// A flush is already due.
+		}
+	}
+
+	return
+}
+
+// Encodes a series with a single sample. Labels are sorted by name, as receivers expect.
+func (r *RemoteWriteStorage) series(
+	name string,
+	constLabels, labels map[string]string,
+	value float64,
+	timestamp int64,
+) (series []byte) {
+	var (
+		names  []string // Sorted label names.
+		sample []byte   // Encoded sample.
+
+		values = map[string]string{
+			"__name__": name,
+			"instance": (*r).instance,
+			"job":      PROMETHEUS_JOB,
+		} // Label values.
+	)
+
+	for k, v := range constLabels {
+		values[k] = v
+	}
+	for k, v := range labels {
+		values[k] = v
+	}
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		var label []byte // Encoded label.
+		label = protowire.AppendTag(label, PROMPB_LABEL_NAME, protowire.BytesType)
+		label = protowire.AppendString(label, k)
+		label = protowire.AppendTag(label, PROMPB_LABEL_VALUE, protowire.BytesType)
+		label = protowire.AppendString(label, values[k])
+		series = protowire.AppendTag(series, PROMPB_TIMESERIES_LABELS, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+
+	sample = protowire.AppendTag(sample, PROMPB_SAMPLE_VALUE, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, PROMPB_SAMPLE_TIMESTAMP, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(timestamp))
+	series = protowire.AppendTag(series, PROMPB_TIMESERIES_SAMPLES, protowire.BytesType)
+
+	return protowire.AppendBytes(series, sample)
+}
+
+// Sends the oldest batch of queued series, retrying with backoff until the receiver accepts or
+// rejects it. Rejected batches are dropped, since sending them again would fail the same way, as
+// are batches still failing once out of retries. Returns whether series remain queued.
+func (r *RemoteWriteStorage) flush() bool {
+	var (
+		backoff = (*r).backoff // Delay before the next retry.
+		batch   [][]byte       // Series being sent.
+	)
+
+	(*r).mutex.Lock()
+	batch = (*r).queue[:min(len((*r).queue), REMOTE_WRITE_BATCH_SIZE)]
+	(*r).mutex.Unlock()
+	if len(batch) == 0 {
+		return false
+	}
+
+	for retries := 0; ; retries++ {
+		retry, err := (*r).send(bytes.Join(batch, nil))
+		if err == nil {
+			break
+		}
+		if !retry {
+			slog.Error("Dropping rejected remote write batch", "address", (*r).address, "err", err)
+			break
+		}
+		if retries == REMOTE_WRITE_MAX_RETRIES {
+			slog.Error("Dropping remote write batch, out of retries", "address", (*r).address, "err", err)
+			break
+		}
+		slog.Warn("Retrying remote write", "address", (*r).address, "err", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, REMOTE_WRITE_MAX_BACKOFF)
+	}
+
+	(*r).mutex.Lock()
+	defer (*r).mutex.Unlock()
+
+	(*r).queue = (*r).queue[len(batch):]
+	for _, series := range batch {
+		(*r).sent += len(series)
+	}
+	if len((*r).queue) == 0 || (*r).sent >= REMOTE_WRITE_COMPACT_BYTES {
+		if err := (*r).compact(); err != nil {
+			slog.Error("Unable to compact remote write queue", "path", (*r).queuePath, "err", err)
+		}
+	}
+
+	return len((*r).queue) > 0
+}
+
+// Sends encoded series to the receiver. Returns whether a failure is worth retrying.
+func (r *RemoteWriteStorage) send(series []byte) (retry bool, err error) {
+	var (
+		request  *http.Request  // Request to the receiver.
+		response *http.Response // Response from the receiver.
+	)
+
+	request, err = http.NewRequest(
+		http.MethodPost, (*r).address, bytes.NewReader(snappyEncode(series)))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", PROMETHEUS_JOB)
+	request.Header.Set("X-Prometheus-Remote-Write-Version", REMOTE_WRITE_VERSION)
+
+	if response, err = (*r).client.Do(request); err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		retry = true
+	}
+
+	return retry, errors.New(fmt.Sprintf("Unexpected remote write response: %s", response.Status))
+}
+
+// Rewrites the queue on disk with only the series still queued. The rewritten queue is synced
+// before replacing the old one, and is appended to from then on, so that a crash never loses series.
+// Expects the mutex to be held.
+func (r *RemoteWriteStorage) compact() (err error) {
+	var (
+		queueFile *os.File // Rewritten queue.
+
+		tempPath = (*r).queuePath + ".tmp" // Where the queue is rewritten.
+	)
+
+	queueFile, err = os.OpenFile(
+		tempPath, os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fs.FileMode(0660))
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempPath)
+	if _, err = queueFile.Write(bytes.Join((*r).queue, nil)); err == nil {
+		err = queueFile.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tempPath, (*r).queuePath)
+	}
+	if err != nil {
+		// Keep appending to the old queue, which still holds every queued series.
+		queueFile.Close()
+		return
+	}
+
+	(*r).queueFile.Close()
+	(*r).queueFile, (*r).sent = queueFile, 0
+
+	return syncDir(filepath.Dir((*r).queuePath))
+}
+
+// Loads series queued on disk. A series cut short, such as by a crash while it was written, is
+// discarded along with anything after it.
+func (r *RemoteWriteStorage) load() (err error) {
+	var (
+		data   []byte // Contents of the queue on disk.
+		offset int    // Offset following the last complete series.
+	)
+
+	if data, err = os.ReadFile((*r).queuePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	for offset < len(data) {
+		_, _, tagLength := protowire.ConsumeTag(data[offset:])
+		if tagLength < 0 {
+			break
+		}
+		_, length := protowire.ConsumeBytes(data[offset+tagLength:])
+		if length < 0 {
+			break
+		}
+		(*r).queue = append((*r).queue, data[offset:offset+tagLength+length])
+		offset += tagLength + length
+	}
+	if len((*r).queue) > 0 {
+		slog.Info("Loaded remote write queue", "path", (*r).queuePath, "series", len((*r).queue))
+	}
+
+	if (*r).queueFile, err = os.OpenFile(
+		(*r).queuePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fs.FileMode(0660)); err != nil {
+		return
+	}
+	if offset < len(data) {
+		slog.Warn("Discarding incomplete remote write series", "path", (*r).queuePath)
+		err = (*r).queueFile.Truncate(int64(offset))
+	}
+
+	return
+}
+
+// Sends queued series, whenever a batch is full and periodically otherwise.
+func (r *RemoteWriteStorage) run() {
+	ticker := time.NewTicker(REMOTE_WRITE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-(*r).flushChan:
+		}
+		for (*r).flush() {
+		}
+	}
+}
+
+// Create a remote write storage with a queue at a path, loading anything queued there.
+func newRemoteWriteStorage(
+	address, queuePath string,
+	metrics map[string][]MetricConfig,
+) (storage *RemoteWriteStorage, err error) {
+	var (
+		instance string // Prometheus instance value.
+	)
+
+	if instance, err = getPromInstance(); err != nil {
+		return
+	}
+
+	storage = &RemoteWriteStorage{
+		address:   address,
+		backoff:   REMOTE_WRITE_MIN_BACKOFF,
+		client:    &http.Client{Timeout: REMOTE_WRITE_TIMEOUT},
+		configs:   metrics,
+		flushChan: make(chan bool, 1),
+		instance:  instance,
+		mutex:     &sync.Mutex{},
+		queuePath: queuePath,
+	}
+	err = storage.load()
+
+	return
+}
+
+// Create a new storage for a Prometheus remote write endpoint, with the configuration of the
+// metrics of each query. Series are queued in the user cache directory until sent.
+func NewRemoteWriteStorage(
+	address string,
+	metrics map[string][]MetricConfig,
+) (storage *RemoteWriteStorage, err error) {
+	var (
+		userCacheDir string // User cache directory, contextual to OS.
+	)
+
+	if userCacheDir, err = os.UserCacheDir(); err != nil {
+		return
+	}
+	queueDir := filepath.Join(userCacheDir, STORAGE_FILE_DIR)
+	if err = os.MkdirAll(queueDir, fs.FileMode(0770)); err != nil {
+		return
+	}
+
+	storage, err = newRemoteWriteStorage(address, filepath.Join(
+		queueDir, fmt.Sprintf(REMOTE_WRITE_QUEUE_FORMAT, normalizeString(address))), metrics)
+	if err != nil {
+		return
+	}
+	go storage.run()
+
+	return
+}
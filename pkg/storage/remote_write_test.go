@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sample of a series received through remote write.
+type testRemoteWriteSample struct {
+	labels    map[string]string
+	timestamp int64
+	value     float64
+}
+
+// Decodes the series of a remote write request, with a sample for each.
+func testRemoteWriteDecode(t *testing.T, body []byte) (samples []testRemoteWriteSample) {
+	// Consumes the fields of a message, supplying each to a function.
+	consume := func(message []byte, f func(protowire.Number, protowire.Type, []byte)) {
+		for len(message) > 0 {
+			number, fieldType, n := protowire.ConsumeTag(message)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			message = message[n:]
+			n = protowire.ConsumeFieldValue(number, fieldType, message)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			f(number, fieldType, message[:n])
+			message = message[n:]
+		}
+	}
+	bytesOf := func(value []byte) []byte {
+		b, _ := protowire.ConsumeBytes(value)
+		return b
+	}
+
+	request, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consume(request, func(_ protowire.Number, _ protowire.Type, series []byte) {
+		sample := testRemoteWriteSample{labels: make(map[string]string)}
+		consume(bytesOf(series), func(number protowire.Number, _ protowire.Type, field []byte) {
+			switch number {
+			case PROMPB_TIMESERIES_LABELS:
+				var name, value string
+				consume(bytesOf(field), func(number protowire.Number, _ protowire.Type, v []byte) {
+					if number == PROMPB_LABEL_NAME {
+						name = string(bytesOf(v))
+					} else {
+						value = string(bytesOf(v))
+					}
+				})
+				sample.labels[name] = value
+			case PROMPB_TIMESERIES_SAMPLES:
+				consume(bytesOf(field), func(number protowire.Number, _ protowire.Type, v []byte) {
+					if number == PROMPB_SAMPLE_VALUE {
+						bits, _ := protowire.ConsumeFixed64(v)
+						sample.value = math.Float64frombits(bits)
+					} else {
+						timestamp, _ := protowire.ConsumeVarint(v)
+						sample.timestamp = int64(timestamp)
+					}
+				})
+			}
+		})
+		samples = append(samples, sample)
+	})
+
+	return
+}
+
+// Starts a remote write receiver responding with statuses in turn, then successfully, recording
+// the samples of requests it accepts.
+func testRemoteWriteReceiver(
+	t *testing.T,
+	statuses ...int,
+) (server *httptest.Server, samples func() []testRemoteWriteSample) {
+	var (
+		mutex    sync.Mutex              // Mutex for managing received samples.
+		received []testRemoteWriteSample // Samples of accepted requests.
+	)
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if r.Header.Get("Content-Encoding") != "snappy" ||
+			r.Header.Get("X-Prometheus-Remote-Write-Version") != REMOTE_WRITE_VERSION {
+			t.Errorf("Got: %v Expected: %v\n", r.Header, "remote write headers")
+		}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, testRemoteWriteDecode(t, body)...)
+	}))
+
+	return server, func() []testRemoteWriteSample {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received
+	}
+}
+
+// Creates a remote write storage queueing in a directory, retrying quickly.
+func testRemoteWriteStorage(
+	t *testing.T,
+	address, dir string,
+	metrics map[string][]MetricConfig,
+) *RemoteWriteStorage {
+	storage, err := newRemoteWriteStorage(address, filepath.Join(dir, "queue"), metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	(*storage).backoff = time.Millisecond
+
+	return storage
+}
+
+func TestRemoteWriteStoragePut(t *testing.T) {
+	server, samples := testRemoteWriteReceiver(t)
+	defer server.Close()
+
+	storage := testRemoteWriteStorage(t, server.URL, t.TempDir(), map[string][]MetricConfig{
+		"fizz": {
+			{ConstLabels: map[string]string{"env": "test"}},
+			{Label: "bar", Name: "buzz"},
+		},
+	})
+	result := Result{
+		Time:     testTime(),
+		Values:   Values{int64(1), 2.5, "baz"},
+		Duration: 1500 * time.Millisecond,
+		Status:   RESULT_STATUS_OK,
+	}
+
+	// It queues series of values that are numbers, and reports the rest.
+	if err := storage.Put("fizz", []string{"foo", "bar", "baz"}, result); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "an error for the value that isn't a number")
+	}
+	if got := len((*storage).queue); got != 4 {
+		t.Errorf("Got: %v Expected: %v\n", got, 4)
+	}
+
+	// It sends queued series, named and labeled as they are by other Prometheus integrations.
+	if storage.flush() {
+		t.Errorf("Got: %v Expected: %v\n", true, false)
+	}
+	expected := map[string]testRemoteWriteSample{
+		"shui_fizz_duration_seconds": {map[string]string{"env": "test"}, 0, 1.5},
+		"shui_fizz_exit_code":        {map[string]string{"env": "test"}, 0, 0},
+		"shui_fizz": {
+			map[string]string{"env": "test", PROMETHEUS_METRIC_LABEL: "foo"}, 0, 1,
+		},
+		"buzz": {map[string]string{PROMETHEUS_METRIC_LABEL: "bar"}, 0, 2.5},
+	}
+	got := samples()
+	if len(got) != len(expected) {
+		t.Fatalf("Got: %v Expected: %v\n", got, expected)
+	}
+	for _, sample := range got {
+		want, ok := expected[sample.labels["__name__"]]
+		if !ok || sample.value != want.value || sample.timestamp != testTime().UnixMilli() ||
+			sample.labels["job"] != PROMETHEUS_JOB || sample.labels["instance"] == "" {
+			t.Errorf("Got: %v Expected: %v\n", sample, want)
+		}
+		for k, v := range want.labels {
+			if sample.labels[k] != v {
+				t.Errorf("Got: %v Expected: %v\n", sample.labels, want.labels)
+			}
+		}
+	}
+
+	// It empties the queue on disk once sent.
+	if info, err := os.Stat((*storage).queuePath); err != nil || info.Size() != 0 {
+		t.Errorf("Got: %v Expected: %v\n", info, "an empty queue")
+	}
+}
+
+func TestRemoteWriteStorageRetry(t *testing.T) {
+	server, samples := testRemoteWriteReceiver(
+		t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadRequest)
+	defer server.Close()
+
+	storage := testRemoteWriteStorage(t, server.URL, t.TempDir(), nil)
+	failed := Result{Time: testTime(), Status: RESULT_STATUS_TIMEOUT}
+
+	// It retries batches until they are rejected, then drops them.
+	storage.Put("fizz", []string{}, failed)
+	storage.flush()
+	if got := len(samples()); got != 0 {
+		t.Errorf("Got: %v Expected: %v\n", got, 0)
+	}
+
+	// It sends batches once they are accepted.
+	storage.Put("fizz", []string{}, failed)
+	storage.flush()
+	if got := len(samples()); got != 2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2)
+	}
+}
+
+func TestRemoteWriteStorageDrop(t *testing.T) {
+	var statuses []int // Responses for every attempt.
+	for i := 0; i <= REMOTE_WRITE_MAX_RETRIES; i++ {
+		statuses = append(statuses, http.StatusBadGateway)
+	}
+	server, samples := testRemoteWriteReceiver(t, statuses...)
+	defer server.Close()
+
+	storage := testRemoteWriteStorage(t, server.URL, t.TempDir(), nil)
+	failed := Result{Time: testTime(), Status: RESULT_STATUS_TIMEOUT}
+
+	// It drops batches once out of retries.
+	storage.Put("fizz", []string{}, failed)
+	if storage.flush() || len((*storage).queue) != 0 {
+		t.Errorf("Got: %v Expected: %v\n", len((*storage).queue), 0)
+	}
+	storage.Put("fizz", []string{}, failed)
+	storage.flush()
+	if got := len(samples()); got != 2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2)
+	}
+
+	// It drops results without failing once the queue is full.
+	for len((*storage).queue) < REMOTE_WRITE_MAX_QUEUE {
+		storage.Put("fizz", []string{}, failed)
+	}
+	if err := storage.Put("fizz", []string{}, failed); err != nil {
+		t.Errorf("Got: %v Expected: %v\n", err, nil)
+	}
+	if got := len((*storage).queue); got != REMOTE_WRITE_MAX_QUEUE {
+		t.Errorf("Got: %v Expected: %v\n", got, REMOTE_WRITE_MAX_QUEUE)
+	}
+}
+
+func TestRemoteWriteStorageQueue(t *testing.T) {
+	server, samples := testRemoteWriteReceiver(t)
+	defer server.Close()
+
+	dir := t.TempDir()
+	storage := testRemoteWriteStorage(t, server.URL, dir, nil)
+	for i := 0; i < REMOTE_WRITE_BATCH_SIZE; i++ {
+		storage.Put("fizz", []string{}, Result{Time: testTime(), Status: RESULT_STATUS_TIMEOUT})
+	}
+
+	// It signals that a batch is full.
+	select {
+	case <-(*storage).flushChan:
+	default:
+		t.Errorf("Got: %v Expected: %v\n", nil, "a flush signal")
+	}
+
+	// It loads queued series after a restart, discarding a series cut short.
+	(*storage).queueFile.Write([]byte{0x0a, 0xff, 0x01, 0x0a})
+	(*storage).queueFile.Close()
+	storage = testRemoteWriteStorage(t, server.URL, dir, nil)
+	if got := len((*storage).queue); got != 2*REMOTE_WRITE_BATCH_SIZE {
+		t.Errorf("Got: %v Expected: %v\n", got, 2*REMOTE_WRITE_BATCH_SIZE)
+	}
+
+	// It sends batches until the queue is empty.
+	for storage.flush() {
+	}
+	if got := len(samples()); got != 2*REMOTE_WRITE_BATCH_SIZE {
+		t.Errorf("Got: %v Expected: %v\n", got, 2*REMOTE_WRITE_BATCH_SIZE)
+	}
+
+	// It keeps queueing after loading.
+	storage.Put("fizz", []string{}, Result{Time: testTime(), Status: RESULT_STATUS_TIMEOUT})
+	storage.flush()
+	if got := len(samples()); got != 2*REMOTE_WRITE_BATCH_SIZE+2 {
+		t.Errorf("Got: %v Expected: %v\n", got, 2*REMOTE_WRITE_BATCH_SIZE+2)
+	}
+}
+
+func TestRemoteWriteStorageCompact(t *testing.T) {
+	server, _ := testRemoteWriteReceiver(t)
+	defer server.Close()
+
+	storage := testRemoteWriteStorage(t, server.URL, t.TempDir(), nil)
+	failed := Result{Time: testTime(), Status: RESULT_STATUS_TIMEOUT}
+	size := func() int64 {
+		info, err := os.Stat((*storage).queuePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	for i := 0; i < REMOTE_WRITE_BATCH_SIZE; i++ {
+		storage.Put("fizz", []string{}, failed)
+	}
+	queued := size()
+
+	// It keeps sent series on disk until enough are sent.
+	storage.flush()
+	if got := size(); got != queued {
+		t.Errorf("Got: %v Expected: %v\n", got, queued)
+	}
+
+	// It keeps queueing to the old queue when the queue can't be rewritten.
+	tempPath := (*storage).queuePath + ".tmp"
+	os.Mkdir(tempPath, 0770)
+	if err := storage.compact(); err == nil {
+		t.Errorf("Got: %v Expected: %v\n", err, "an error")
+	}
+	if err := storage.Put("fizz", []string{}, failed); err != nil || size() <= queued {
+		t.Errorf("Got: %v Expected: %v\n", err, "a queued result")
+	}
+	os.Remove(tempPath)
+
+	// It compacts once the queue is empty, queueing to the rewritten queue afterward.
+	for storage.flush() {
+	}
+	if got := size(); got != 0 {
+		t.Errorf("Got: %v Expected: %v\n", got, 0)
+	}
+	if storage.Put("fizz", []string{}, failed); size() == 0 {
+		t.Errorf("Got: %v Expected: %v\n", 0, "a queued result")
+	}
+}
@@ -4,9 +4,12 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	_ "log/slog"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
@@ -16,6 +19,32 @@ import (
 // Tokenized result value.
 type Values []interface{}
 
+// Implements json.Unmarshaler. Numbers are restored as integers where possible, preserving the
+// distinction between integer and float values that JSON otherwise loses.
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var (
+		decoder = json.NewDecoder(bytes.NewReader(data)) // Decoder that preserves numbers.
+		values  []interface{}                            // Decoded values.
+	)
+
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return err
+	}
+	for i, value := range values {
+		if number, ok := value.(json.Number); ok {
+			if intValue, err := number.Int64(); err == nil {
+				values[i] = intValue
+			} else {
+				values[i], _ = number.Float64()
+			}
+		}
+	}
+	*v = values
+
+	return nil
+}
+
 // Retrieve an indexed token.
 func (v Values) Get(index int) (result interface{}) {
 	// Return an empty value if we didn't find an indexed value. This can happen if empty results are
@@ -27,11 +56,59 @@ func (v Values) Get(index int) (result interface{}) {
 	return
 }
 
+// Represents the outcome of a query execution that produced a result.
+type ResultStatus int
+
+// Fetches a common name from a result status value.
+func (s ResultStatus) String() string {
+	return ResultStatuses[s]
+}
+
+// Result status constants.
+const (
+	RESULT_STATUS_OK      ResultStatus = iota // The query completed. First to serve as the 'default.'
+	RESULT_STATUS_TIMEOUT                     // The query was cancelled after exceeding a timeout.
+)
+
+var (
+	// Mapping of result status constants to a common result status name.
+	ResultStatuses = map[ResultStatus]string{
+		RESULT_STATUS_OK:      "ok",
+		RESULT_STATUS_TIMEOUT: "timed out",
+	}
+)
+
 // Individual result.
 type Result struct {
 	Time   time.Time // Time the result was created.
 	Value  string    // Raw value of the result.
 	Values Values    // Tokenized value of the result.
+
+	// Every row of values, for results parsed into more than one. The first row is also the result's
+	// values.
+	Rows []Values `json:",omitempty"`
+
+	// Labels of the values, when they differ from those of the series, as for values produced by
+	// expressions.
+	Labels []string `json:",omitempty"`
+
+	// Metadata about the query execution that produced the result.
+	Duration time.Duration // How long the query took to execute.
+	ExitCode int           // Exit code of the query, if it was a command.
+	Status   ResultStatus  // Outcome of the query.
+	Stderr   string        // Error output of the query, if it was a command.
+
+	// Summary of the results this result combines, if it was produced by downsampling.
+	Aggregate *Aggregate `json:",omitempty"`
+}
+
+// Gets every row of values in the result.
+func (r *Result) AllRows() []Values {
+	if len((*r).Rows) > 0 {
+		return (*r).Rows
+	}
+
+	return []Values{(*r).Values}
 }
 
 // Determines whether this is an empty result.
@@ -48,48 +125,93 @@ func (r *Result) IsEmptyValues() bool {
 func (r *Result) Map(labels []string) map[string]interface{} {
 	resultMap := make(map[string]interface{}, len(r.Values))
 	for i, value := range r.Values {
-		resultMap[labels[i]] = value
+		if len(labels) > i {
+			resultMap[labels[i]] = value
+		} else {
+			// There is no label for this value, which can happen if a series began with results that
+			// had fewer values (e.g. timed out queries)--fall back to an index label.
+			resultMap[strconv.Itoa(i)] = value
+		}
 	}
 
 	return resultMap
 }
 
+// Labels applied to a results series from a point in time onward.
+type LabelSchema struct {
+	Labels []string  // Applied labels.
+	Since  time.Time // Time from which the labels apply.
+}
+
 // Collection of results.
 type Results struct {
 	// Meta field for result values acting as a name, corresponding by index. In the event that no
 	// explicit labels are defined, the indexes are the labels.
 	Labels []string
+	// History of explicitly defined labels, in the order they were applied. Results created prior to
+	// any label schema use index labels.
+	LabelSchemas []LabelSchema
 	// Stored results.
 	Results []Result
+
+	bytes int // Approximate size of stored results.
 }
 
 // Get a result based on a timestamp.
 func (r *Results) get(time time.Time) Result {
-	for _, result := range (*r).Results {
-		if result.Time.Compare(time) == 0 {
-			// We found a result to return.
-			return result
-		}
+	if i := r.search(time); i < len((*r).Results) && (*r).Results[i].Time.Equal(time) {
+		// We found a result to return.
+		return (*r).Results[i]
 	}
 
 	// Return an empty result if nothing was discovered.
 	return Result{}
 }
 
-// Gets results based on a start and end timestamp.
-func (r *Results) getRange(startTime time.Time, endTime time.Time) (found []Result) {
-	for _, result := range (*r).Results {
-		if result.Time.Compare(startTime) >= 0 {
-			if result.Time.Compare(endTime) > 0 {
-				// Break out of the loop if we've exhausted the upper bounds of the range.
-				break
-			} else {
-				found = append(found, result)
-			}
-		}
+// Gets results created after a timestamp. The returned slice shares storage with the series and
+// must not be modified.
+func (r *Results) getAfter(time time.Time) []Result {
+	var (
+		end = len((*r).Results) // End of the results.
+	)
+
+	return (*r).Results[r.searchAfter(time):end:end]
+}
+
+// Gets results created before a timestamp. The returned slice shares storage with the series and
+// must not be modified.
+func (r *Results) getBefore(time time.Time) []Result {
+	var (
+		end = r.search(time) // Index after the last result before the timestamp.
+	)
+
+	return (*r).Results[:end:end]
+}
+
+// Gets the most recent results, up to a count. The returned slice shares storage with the series and
+// must not be modified.
+func (r *Results) getLatest(count int) []Result {
+	var (
+		end = len((*r).Results) // End of the results.
+	)
+
+	return (*r).Results[max(end-count, 0):end:end]
+}
+
+// Gets results based on a start and end timestamp, inclusive. The returned slice shares storage with
+// the series and must not be modified.
+func (r *Results) getRange(startTime time.Time, endTime time.Time) []Result {
+	var (
+		start = r.search(startTime)    // Index of the first result in range.
+		end   = r.searchAfter(endTime) // Index after the last result in range.
+	)
+
+	if end < start {
+		// The range is inverted.
+		return nil
 	}
 
-	return
+	return (*r).Results[start:end:end]
 }
 
 // Given a filter, return the corresponding value index.
@@ -97,19 +219,67 @@ func (r *Results) getValueIndex(filter string) int {
 	return slices.Index((*r).Labels, filter)
 }
 
+// Get the labels that applied to a result, based on when it was created, unless it has its own.
+func (r *Results) labelsFor(result Result) []string {
+	if len(result.Labels) > 0 {
+		return result.Labels
+	}
+	for i := len((*r).LabelSchemas) - 1; i >= 0; i-- {
+		if !result.Time.Before((*r).LabelSchemas[i].Since) {
+			return (*r).LabelSchemas[i].Labels
+		}
+	}
+
+	return defaultLabels(len(result.Values))
+}
+
 // Put a new compound result.
 func (r *Results) put(value string, values ...interface{}) Result {
-	next := Result{
-		Time:   time.Now(),
+	return r.putResult(Result{
 		Value:  value,
 		Values: values,
+	})
+}
+
+// Put a new pre-built result. The result time is assigned if one isn't already present.
+func (r *Results) putResult(next Result) Result {
+	if next.Time.IsZero() {
+		next.Time = time.Now()
 	}
 
 	(*r).Results = append((*r).Results, next)
+	(*r).bytes += next.size()
+
+	// Without explicit labels, index labels grow to account for results with more values than seen
+	// before.
+	if len((*r).LabelSchemas) == 0 && len(next.Values) > len((*r).Labels) {
+		(*r).Labels = defaultLabels(len(next.Values))
+	}
 
 	return next
 }
 
+// Apply new labels from a point in time onward.
+func (r *Results) putLabels(schema LabelSchema) {
+	(*r).Labels = schema.Labels
+	(*r).LabelSchemas = append((*r).LabelSchemas, schema)
+}
+
+// Finds the index of the first result created at or after a timestamp. Results are stored in the
+// order they are created, so this is a binary search.
+func (r *Results) search(time time.Time) int {
+	return sort.Search(len((*r).Results), func(i int) bool {
+		return !(*r).Results[i].Time.Before(time)
+	})
+}
+
+// Finds the index of the first result created after a timestamp.
+func (r *Results) searchAfter(time time.Time) int {
+	return sort.Search(len((*r).Results), func(i int) bool {
+		return (*r).Results[i].Time.After(time)
+	})
+}
+
 // Show all currently stored results.
 func (r *Results) show() {
 	for _, result := range (*r).Results {
@@ -118,13 +288,20 @@ func (r *Results) show() {
 	}
 }
 
+// Creates index labels, used when no explicit labels are defined.
+func defaultLabels(size int) (labels []string) {
+	labels = make([]string, size)
+	for i := range labels {
+		labels[i] = strconv.Itoa(i)
+	}
+
+	return
+}
+
 // Creates new results.
 func newResults(size int) (results Results) {
 	// Iniitialize labels.
-	results.Labels = make([]string, size)
-	for i := range results.Labels {
-		results.Labels[i] = strconv.Itoa(i)
-	}
+	results.Labels = defaultLabels(size)
 
 	return
 }
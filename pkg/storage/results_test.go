@@ -104,6 +104,11 @@ func TestResultsGetRange(t *testing.T) {
 		}
 	}
 
+	// It returns no results for an inverted time range.
+	if got = results.getRange(testTime().Add(time.Second*30), testTime()); len(got) != 0 {
+		t.Errorf("Got: %v\n", got)
+	}
+
 	// It returns a single result if the time range is restricted.
 	got = results.getRange(testTime(), testTime())
 	if len(got) != 1 || !reflect.DeepEqual(got[0], expected.Results[0]) {
@@ -111,6 +116,43 @@ func TestResultsGetRange(t *testing.T) {
 	}
 }
 
+func TestResultsGetBefore(t *testing.T) {
+	results := testResults()
+
+	// It gets results strictly before a time.
+	got := results.getBefore(testTime().Add(time.Second * 30))
+	if len(got) != 1 || !reflect.DeepEqual(got[0], results.Results[0]) {
+		t.Errorf("Got: %v Expected: %v\n", got, results.Results[:1])
+	}
+
+	// It gets no results before the first result.
+	if got = results.getBefore(testTime()); len(got) != 0 {
+		t.Errorf("Got: %v\n", got)
+	}
+
+	// Appending to retrieved results doesn't affect stored results.
+	got = results.getBefore(testTime().Add(time.Second * 30))
+	_ = append(got, Result{Value: "fizz"})
+	if results.Results[1].Value != "bar" {
+		t.Errorf("Got: %v Expected: %v\n", results.Results[1].Value, "bar")
+	}
+}
+
+func TestResultsGetLatest(t *testing.T) {
+	results := testResults()
+
+	// It gets the most recent results.
+	got := results.getLatest(1)
+	if len(got) != 1 || !reflect.DeepEqual(got[0], results.Results[1]) {
+		t.Errorf("Got: %v Expected: %v\n", got, results.Results[1:])
+	}
+
+	// It gets all results when asking for more than exist.
+	if got = results.getLatest(5); len(got) != 2 {
+		t.Errorf("Got: %v\n", got)
+	}
+}
+
 func TestResultsPut(t *testing.T) {
 	results := testResults()
 
@@ -131,3 +173,24 @@ func TestResultsPut(t *testing.T) {
 		}
 	}
 }
+
+func TestResultsLabelsFor(t *testing.T) {
+	results := testResults()
+	results.LabelSchemas = []LabelSchema{
+		{Labels: []string{"fizz"}, Since: testTime().Add(time.Second * 15)},
+	}
+
+	// It uses index labels for results prior to any label schema.
+	got := results.labelsFor(Result{Time: testTime(), Values: Values{"foo", "bar"}})
+	expected := []string{"0", "1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It uses the label schema applied at the time of the result.
+	got = results.labelsFor(results.Results[1])
+	expected = []string{"fizz"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Retention of results.
+//
+// Retention policies limit the results kept for a query by age, count, or size, and may downsample
+// older results by combining them into aggregates. Policies are enforced when they are first applied
+// and whenever results are added. Persisted results reflect retention once storage is next
+// compacted into a snapshot.
+
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	RESULT_BASE_SIZE      = 64 // Approximate size of a result, excluding variable length data.
+	RESULT_VALUE_SIZE     = 16 // Approximate size of a single result value, excluding strings.
+	RESULT_AGGREGATE_SIZE = 32 // Approximate size of a single value aggregate.
+)
+
+// Policy for limiting the results kept for a query. Zero values impose no limits.
+type RetentionPolicy struct {
+	Downsample      time.Duration `mapstructure:"downsample"`       // Width of buckets older results are combined into.
+	DownsampleAfter time.Duration `mapstructure:"downsample-after"` // Age after which results are downsampled.
+	MaxAge          time.Duration `mapstructure:"max-age"`          // Age after which results are removed.
+	MaxBytes        int           `mapstructure:"max-bytes"`        // Approximate size of results to keep.
+	MaxCount        int           `mapstructure:"max-count"`        // Number of results to keep.
+}
+
+// Determines whether this policy imposes any limits.
+func (p *RetentionPolicy) IsEmpty() bool {
+	return *p == RetentionPolicy{}
+}
+
+// Aggregation of a single value over downsampled results. Only numeric values are aggregated.
+type ValueAggregate struct {
+	Avg, Max, Min float64
+	Count         int // Number of numeric values aggregated.
+}
+
+// Summary of results combined by downsampling. A downsampled result otherwise takes the values of
+// the last result it combines.
+type Aggregate struct {
+	Count  int              // Number of results combined.
+	Start  time.Time        // Start of the downsampling bucket.
+	Values []ValueAggregate // Aggregations of values, corresponding by index.
+}
+
+// Combines results into a single result.
+func downsample(results []Result, start time.Time) (downsampled Result) {
+	var (
+		aggregate = Aggregate{Count: len(results), Start: start} // Summary of the results.
+		sums      []float64                                      // Running sums for averages.
+	)
+
+	for _, result := range results {
+		// Account for results that have more values than those before them.
+		for len(aggregate.Values) < len(result.Values) {
+			aggregate.Values = append(aggregate.Values, ValueAggregate{})
+			sums = append(sums, 0)
+		}
+
+		for i, value := range result.Values {
+			var number float64 // Numeric value to aggregate.
+
+			switch value.(type) {
+			case int64:
+				number = float64(value.(int64))
+			case float64:
+				number = value.(float64)
+			default:
+				continue
+			}
+
+			if aggregate.Values[i].Count == 0 || number < aggregate.Values[i].Min {
+				aggregate.Values[i].Min = number
+			}
+			if aggregate.Values[i].Count == 0 || number > aggregate.Values[i].Max {
+				aggregate.Values[i].Max = number
+			}
+			aggregate.Values[i].Count++
+			sums[i] += number
+		}
+	}
+	for i := range aggregate.Values {
+		if aggregate.Values[i].Count > 0 {
+			aggregate.Values[i].Avg = sums[i] / float64(aggregate.Values[i].Count)
+		}
+	}
+
+	downsampled = results[len(results)-1]
+	downsampled.Aggregate = &aggregate
+
+	return
+}
+
+// Approximates the amount of memory a result uses.
+func (r *Result) size() (size int) {
+	size = RESULT_BASE_SIZE + len((*r).Value) + len((*r).Stderr)
+	for _, row := range append([]Values{(*r).Values}, (*r).Rows...) {
+		for _, value := range row {
+			size += RESULT_VALUE_SIZE
+			if s, ok := value.(string); ok {
+				size += len(s)
+			}
+		}
+	}
+	if (*r).Aggregate != nil {
+		size += RESULT_AGGREGATE_SIZE * len((*r).Aggregate.Values)
+	}
+
+	return
+}
+
+// Combines results older than the policy allows into downsampled results. Only complete buckets are
+// downsampled. Returns whether any results were changed.
+func (r *Results) downsample(policy RetentionPolicy, now time.Time) bool {
+	var (
+		downsampled []Result // Results produced by downsampling.
+		end         int      // End of the raw results to downsample.
+
+		cutoff = now.Add(-policy.DownsampleAfter) // Results before this may be downsampled.
+		// Downsampled results always precede raw results.
+		start = sort.Search(len((*r).Results), func(i int) bool {
+			return (*r).Results[i].Aggregate == nil
+		})
+	)
+
+	for end = start; end < len((*r).Results); {
+		var (
+			bucketStart = (*r).Results[end].Time.Truncate(policy.Downsample) // Start of this bucket.
+			bucketEnd   = bucketStart.Add(policy.Downsample)                 // End of this bucket.
+			next        = end                                                // End of this bucket's results.
+		)
+
+		if bucketEnd.After(cutoff) {
+			// This bucket, and any after it, aren't old enough.
+			break
+		}
+		for next < len((*r).Results) && (*r).Results[next].Time.Before(bucketEnd) {
+			next++
+		}
+
+		downsampled = append(downsampled, downsample((*r).Results[end:next], bucketStart))
+		end = next
+	}
+	if len(downsampled) == 0 {
+		return false
+	}
+
+	for _, result := range (*r).Results[start:end] {
+		(*r).bytes -= result.size()
+	}
+	for _, result := range downsampled {
+		(*r).bytes += result.size()
+	}
+	// Replace results with a new slice, since results previously retrieved may share the old one.
+	results := make([]Result, 0, start+len(downsampled)+len((*r).Results)-end)
+	results = append(results, (*r).Results[:start]...)
+	results = append(results, downsampled...)
+	(*r).Results = append(results, (*r).Results[end:]...)
+
+	return true
+}
+
+// Enforces a retention policy. Returns whether any results were changed.
+func (r *Results) retain(policy RetentionPolicy, now time.Time) (changed bool) {
+	var (
+		drop int // Number of results to remove from the start of the series.
+	)
+
+	// Downsample first, so that limits account for the reduction.
+	if policy.Downsample > 0 {
+		changed = r.downsample(policy, now)
+	}
+
+	if policy.MaxAge > 0 {
+		drop = r.search(now.Add(-policy.MaxAge))
+	}
+	if policy.MaxCount > 0 && len((*r).Results)-drop > policy.MaxCount {
+		drop = len((*r).Results) - policy.MaxCount
+	}
+	if policy.MaxBytes > 0 {
+		bytes := (*r).bytes
+		for _, result := range (*r).Results[:drop] {
+			bytes -= result.size()
+		}
+		for ; drop < len((*r).Results) && bytes > policy.MaxBytes; drop++ {
+			bytes -= (*r).Results[drop].size()
+		}
+	}
+
+	if drop > 0 {
+		for _, result := range (*r).Results[:drop] {
+			(*r).bytes -= result.size()
+		}
+		(*r).Results = (*r).Results[drop:]
+		changed = true
+	}
+
+	return
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Builds results at one minute intervals, ending at a time.
+func testRetentionResults(end time.Time, values ...int64) (results Results) {
+	for i, value := range values {
+		results.putResult(Result{
+			Time:   end.Add(time.Duration(i-len(values)+1) * time.Minute),
+			Values: Values{value},
+		})
+	}
+
+	return
+}
+
+// Extracts the first value of each result.
+func testRetentionValues(results Results) (values []interface{}) {
+	for _, result := range results.Results {
+		values = append(values, result.Values[0])
+	}
+
+	return
+}
+
+func TestRetainMaxAge(t *testing.T) {
+	now := time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+	results := testRetentionResults(now, 1, 2, 3, 4, 5)
+
+	// It removes results older than the maximum age.
+	changed := results.retain(RetentionPolicy{MaxAge: 2 * time.Minute}, now)
+	expected := []interface{}{int64(3), int64(4), int64(5)}
+	if got := testRetentionValues(results); !changed || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It does nothing when all results are recent enough.
+	if changed = results.retain(RetentionPolicy{MaxAge: time.Hour}, now); changed {
+		t.Errorf("Got: %v Expected: %v\n", changed, false)
+	}
+}
+
+func TestRetainMaxCount(t *testing.T) {
+	now := time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+	results := testRetentionResults(now, 1, 2, 3, 4, 5)
+
+	// It keeps only the most recent results.
+	results.retain(RetentionPolicy{MaxCount: 2}, now)
+	expected := []interface{}{int64(4), int64(5)}
+	if got := testRetentionValues(results); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+}
+
+func TestRetainMaxBytes(t *testing.T) {
+	now := time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+	results := testRetentionResults(now, 1, 2, 3, 4, 5)
+	resultSize := results.Results[0].size()
+
+	// It keeps results within the size limit.
+	results.retain(RetentionPolicy{MaxBytes: 3*resultSize - 1}, now)
+	expected := []interface{}{int64(4), int64(5)}
+	if got := testRetentionValues(results); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+	if results.bytes != 2*resultSize {
+		t.Errorf("Got: %v Expected: %v\n", results.bytes, 2*resultSize)
+	}
+}
+
+func TestRetainDownsample(t *testing.T) {
+	now := time.Date(2024, time.June, 10, 12, 0, 0, 0, time.UTC)
+	results := testRetentionResults(now, 1, 2, 3, 4, 5, 6, 7)
+	policy := RetentionPolicy{Downsample: 3 * time.Minute, DownsampleAfter: 2 * time.Minute}
+
+	// It combines complete buckets of older results, leaving recent results alone.
+	results.retain(policy, now)
+	if got := len(results.Results); got != 5 {
+		t.Fatalf("Got: %v Expected: %v\n", got, 5)
+	}
+	expected := &Aggregate{
+		Count:  3,
+		Start:  now.Add(-6 * time.Minute),
+		Values: []ValueAggregate{{Avg: 2, Max: 3, Min: 1, Count: 3}},
+	}
+	if got := results.Results[0].Aggregate; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+	if got := results.Results[0].Values[0]; got != int64(3) {
+		t.Errorf("Got: %v Expected: %v\n", got, int64(3))
+	}
+	expectedValues := []interface{}{int64(4), int64(5), int64(6), int64(7)}
+	if got := testRetentionValues(Results{Results: results.Results[1:]}); !reflect.DeepEqual(
+		got, expectedValues) {
+		t.Errorf("Got: %v Expected: %v\n", got, expectedValues)
+	}
+
+	// It doesn't downsample results again.
+	if changed := results.retain(policy, now); changed {
+		t.Errorf("Got: %v Expected: %v\n", changed, false)
+	}
+}
+
+func TestPutRetention(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	for i := 1; i <= 5; i++ {
+		storage.Put("foo", "", false, int64(i))
+	}
+
+	// It applies a policy immediately.
+	storage.PutRetention(map[string]RetentionPolicy{"foo": {MaxCount: 3}})
+	if got := storage.GetAll("foo"); len(got) != 3 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 3)
+	}
+
+	// It applies a policy as results are added.
+	storage.Put("foo", "", false, int64(6))
+	got := storage.GetAll("foo")
+	if len(got) != 3 || got[2].Values[0] != int64(6) {
+		t.Errorf("Got: %v\n", got)
+	}
+
+	// Readers behind retention don't read past stored results.
+	reader := ReaderIndex(10)
+	if got := storage.GetToIndex("foo", []string{}, &reader); len(got) != 3 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 3)
+	}
+}
+
+func TestPutRetentionPersisted(t *testing.T) {
+	storage := testPersistedStorage(t, t.TempDir())
+	defer storage.Close()
+	for i := 1; i <= 5; i++ {
+		storage.Put("foo", "", true, int64(i))
+		storage.Put("bar", "", true, int64(i))
+	}
+
+	// It compacts storage once for all policies.
+	storage.PutRetention(map[string]RetentionPolicy{"foo": {MaxCount: 3}, "bar": {MaxCount: 2}})
+	if storage.generation != 1 {
+		t.Errorf("Got: %v Expected: %v\n", storage.generation, 1)
+	}
+	if got := len(storage.GetAll("foo")) + len(storage.GetAll("bar")); got != 5 {
+		t.Errorf("Got: %v Expected: %v\n", got, 5)
+	}
+}
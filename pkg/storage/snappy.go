@@ -0,0 +1,94 @@
+//
+// Snappy compression.
+//
+// Only the block format is produced, which is what Prometheus remote write expects. Input is
+// compressed by finding earlier occurrences of each four bytes through a hash table, copying from
+// them where found and writing everything else as literals.
+//
+// See: https://github.com/google/snappy/blob/main/format_description.txt
+
+package storage
+
+import (
+	"encoding/binary"
+)
+
+const (
+	SNAPPY_HASH_BITS  = 14         // Size of the table of earlier occurrences, in bits.
+	SNAPPY_MAX_OFFSET = 1<<16 - 1  // Furthest back a copy may refer to, for copies with 2 byte offsets.
+	SNAPPY_MIN_MATCH  = 4          // Shortest run of bytes worth copying.
+	SNAPPY_HASH_MUL   = 0x1e35a7bd // Multiplier for hashing four bytes.
+	SNAPPY_TAG_COPY_1 = 0x01       // Tag of copies with 1 byte offsets.
+	SNAPPY_TAG_COPY_2 = 0x02       // Tag of copies with 2 byte offsets.
+)
+
+// Writes bytes as a literal.
+func snappyAppendLiteral(dst, literal []byte) []byte {
+	n := len(literal) - 1
+	switch {
+	case len(literal) == 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, literal...)
+}
+
+// Writes a copy of earlier bytes. Copies are at most 64 bytes long, so longer ones are split,
+// leaving at least enough for one more.
+func snappyAppendCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|SNAPPY_TAG_COPY_2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|SNAPPY_TAG_COPY_2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|SNAPPY_TAG_COPY_2, byte(offset), byte(offset>>8))
+	}
+
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|SNAPPY_TAG_COPY_1, byte(offset))
+}
+
+// Compresses bytes in the Snappy block format.
+func snappyEncode(src []byte) (dst []byte) {
+	var (
+		literal int                        // Start of bytes not yet written.
+		table   [1 << SNAPPY_HASH_BITS]int // Positions after earlier occurrences, by hash.
+	)
+
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	for i := 0; i+SNAPPY_MIN_MATCH <= len(src); {
+		key := binary.LittleEndian.Uint32(src[i:])
+		hash := key * SNAPPY_HASH_MUL >> (32 - SNAPPY_HASH_BITS)
+		candidate := table[hash] - 1
+		table[hash] = i + 1
+		if candidate < 0 || i-candidate > SNAPPY_MAX_OFFSET ||
+			binary.LittleEndian.Uint32(src[candidate:]) != key {
+			i++
+			continue
+		}
+
+		length := SNAPPY_MIN_MATCH
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyAppendLiteral(dst, src[literal:i])
+		dst = snappyAppendCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+
+	return snappyAppendLiteral(dst, src[literal:])
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestSnappyEncode(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, input := range [][]byte{
+		{},
+		[]byte("foo"),
+		[]byte("foofoofoofoofoo"),
+		bytes.Repeat([]byte("a"), 100000),
+		bytes.Repeat([]byte("shui_foo_bar"), 5000),
+		random,
+		bytes.Repeat(random[:3000], 2),
+	} {
+		encoded := snappyEncode(input)
+
+		// It decodes to the input with the reference implementation.
+		got, err := snappy.Decode(nil, encoded)
+		if err != nil || !bytes.Equal(got, input) {
+			t.Errorf("Got: %v Expected: %v\n", err, "the input")
+		}
+	}
+
+	// It compresses repetition.
+	if got := len(snappyEncode(bytes.Repeat([]byte("a"), 100000))); got > 5000 {
+		t.Errorf("Got: %v Expected: %v\n", got, "at most 5000 bytes")
+	}
+}
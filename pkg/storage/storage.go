@@ -5,17 +5,17 @@
 // library in a few ways, namely:
 //
 // - It can be used as a library.
-// - It can broadcast events into public Go channels.
-// - It can broadcast events via RPC.
+// - It can broadcast events to any number of subscribers, see `subscription.go`.
+// - It can be exposed via RPC, see `StorageRPC`.
 //
-// Results are stored simply in an ordered sequence, and querying time is linear.
+// Results are stored simply in an ordered sequence, and querying by time is a binary search. Results
+// may be persisted to disk, see `persistence.go`.
 
 package storage
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -27,11 +27,12 @@ import (
 )
 
 const (
-	MAX_EXTERNAL_STORAGES  = 128            // Maximum external storage integrations.
-	MAX_RESULTS            = 128            // Maximum number of result series that may be maintained.
-	PUT_EVENT_CHANNEL_SIZE = 128            // Size of put channels, controlling the amount of waiting results.
-	STORAGE_FILE_DIR       = "shui"         // Directory in user cache to use for storage.
-	STORAGE_FILE_NAME      = "storage.json" // Filename to use for actual storage.
+	MAX_EXTERNAL_STORAGES    = 128              // Maximum external storage integrations.
+	MAX_RESULTS              = 128              // Maximum number of result series that may be maintained.
+	PUT_EVENT_CHANNEL_SIZE   = 128              // Default size of subscription buffers.
+	RPC_FOLLOW_TIMEOUT       = 30 * time.Second // Time an RPC client may wait for new results.
+	STORAGE_FILE_DIR         = "shui"           // Directory in user cache to use for storage.
+	STORAGE_LEGACY_FILE_NAME = "storage.json"   // Filename previously used for storage, prior to logs.
 )
 
 // Returns a results series that has been filtered to a specific set of labels.
@@ -51,10 +52,13 @@ func filterResult(query string, filters, labels []string, result Result) (filter
 		filteredValues = filterSlice(result.Values, filteredIndexes)
 
 		// Reconstruct the result with filtered values.
-		filteredResult = Result{
-			Time:   result.Time,
-			Value:  result.Value,
-			Values: filteredValues,
+		filteredResult = result
+		filteredResult.Values = filteredValues
+		if len(result.Rows) > 0 {
+			filteredResult.Rows = make([]Values, len(result.Rows))
+			for i, row := range result.Rows {
+				filteredResult.Rows[i] = filterSlice(row, filteredIndexes)
+			}
 		}
 	} else {
 		// If not filters were provided, just return the result itself.
@@ -65,14 +69,14 @@ func filterResult(query string, filters, labels []string, result Result) (filter
 }
 
 // Pick items from an arbitrary slice according to provided indexes. If indexes is empty, it will
-// just return the original slice. It is possible to request indexes outside the range of in, which
-// will be ignored.
+// just return the original slice. It is possible to request indexes outside the range of in
+// (including negative indexes, for labels that weren't found), which will be ignored.
 func filterSlice[T interface{}](in []T, indexes []int) (out []T) {
 	if len(indexes) == 0 {
 		out = in
 	} else {
 		for _, index := range indexes {
-			if len(in) > index {
+			if index >= 0 && len(in) > index {
 				out = append(out, in[index])
 			}
 		}
@@ -81,19 +85,28 @@ func filterSlice[T interface{}](in []T, indexes []int) (out []T) {
 	return
 }
 
-// Collection of results mapped to their queries.
+// Collection of results mapped to their queries. Storage is safe for concurrent use. Results should
+// be accessed through methods rather than directly once storage is shared.
 type Storage struct {
-	externalStorages []externalStorage        // Integrated external storages.
-	putEventChans    map[string](chan Result) // Map of queries to put even channels.
-	storageFile      *os.File                 // File for persisting results.
-	storageMutex     *sync.Mutex              // Mutex for managing persistence writes.
+	externalStorages   []externalStorage          // Integrated external storages.
+	generation         int                        // Generation of the current log.
+	lastSync           time.Time                  // Last time the log was synced.
+	logFile            *os.File                   // Log for persisting results.
+	logRecords         int                        // Number of records in the current log.
+	resultsMutex       *sync.RWMutex              // Mutex for managing results and retention.
+	retention          map[string]RetentionPolicy // Map of queries to retention policies.
+	storageDir         string                     // Directory for persisting results.
+	storageMutex       *sync.Mutex                // Mutex for managing persistence writes.
+	subscriptions      map[string][]*Subscription // Map of queries to subscriptions.
+	subscriptionsMutex *sync.RWMutex              // Mutex for managing subscriptions.
+	syncPolicy         SyncPolicy                 // Policy for syncing persisted results.
 
 	Results map[string]*Results // Map of queries to results.
 }
 
 // Initializes a new results series in storage. Must be called when a new results series is created.
 // This function is idempotent in that it will check if results for a query have already been
-// initialized and pass silently if so.
+// initialized and pass silently if so. Expects the results mutex to be held for writing.
 func (s *Storage) newResults(query string, size int) {
 	var (
 		results Results // Results to initialize.
@@ -103,29 +116,34 @@ func (s *Storage) newResults(query string, size int) {
 		// Initialize results.
 		results = newResults(size)
 		(*s).Results[query] = &results
-
-		// Initialize the query's put event channel.
-		(*s).putEventChans[query] = make(chan Result, PUT_EVENT_CHANNEL_SIZE)
 	}
 }
 
-// Saves current storage to disk. Currently this replaces the entire storage file with all the data
-// in storage (a full write-over).
-func (s *Storage) save() error {
-	var (
-		err         error  // General error holder.
-		resultsJson []byte // Bytes as json.
-	)
+// Provides a view of a results series as it is now. Results put afterwards aren't visible in the
+// view, and stored results are never modified in place, so the view may be read without holding any
+// locks. Unknown queries have an empty view.
+func (s *Storage) view(query string) (results Results) {
+	(*s).resultsMutex.RLock()
+	defer (*s).resultsMutex.RUnlock()
 
-	// Lock storage to prevent dirty writes.
-	(*s).storageMutex.Lock()
-	defer (*s).storageMutex.Unlock()
+	if _, ok := (*s).Results[query]; ok {
+		results = *(*s).Results[query]
+	}
 
-	// Translate current storage results into binary json and save it.
-	resultsJson, err = json.MarshalIndent(&s.Results, "", "\t")
-	_, err = (*s).storageFile.WriteAt(resultsJson, 0)
+	return
+}
+
+// Provides views of all results series, see `view`.
+func (s *Storage) views() (views map[string]Results) {
+	(*s).resultsMutex.RLock()
+	defer (*s).resultsMutex.RUnlock()
+
+	views = make(map[string]Results, len((*s).Results))
+	for query, results := range (*s).Results {
+		views[query] = *results
+	}
 
-	return err
+	return
 }
 
 // Adds an external storage.
@@ -136,24 +154,44 @@ func (s *Storage) AddExternalStorage(e externalStorage) {
 
 // Closes a storage. Should be called after all storage operations cease.
 func (s *Storage) Close() {
-	(*s).storageFile.Close()
+	(*s).storageMutex.Lock()
+	defer (*s).storageMutex.Unlock()
+
+	if (*s).logFile != nil {
+		(*s).logFile.Sync()
+		(*s).logFile.Close()
+	}
 }
 
 // Get a result based on a timestamp.
 func (s *Storage) Get(query string, time time.Time) Result {
-	return (*s).Results[query].get(time)
+	results := s.view(query)
+	return results.get(time)
 }
 
-// Get all results.
+// Get all results. The returned slice must not be modified.
 func (s *Storage) GetAll(query string) []Result {
-	return (*s).Results[query].Results
+	results := s.view(query).Results
+	return results[:len(results):len(results)]
+}
+
+// Gets results created after a timestamp. The returned slice must not be modified.
+func (s *Storage) GetAfter(query string, time time.Time) []Result {
+	results := s.view(query)
+	return results.getAfter(time)
+}
+
+// Get the history of explicitly defined labels. The returned slice must not be modified.
+func (s *Storage) GetLabelSchemas(query string) []LabelSchema {
+	schemas := s.view(query).LabelSchemas
+	return schemas[:len(schemas):len(schemas)]
 }
 
 // Get a result's labels.
 func (s *Storage) GetLabels(query string, filters []string) []string {
 	var (
-		filteredIndexes = make([]int, len(filters))  // Indexes for filtering.
-		labels          = (*s).Results[query].Labels // Labels associated with this query.
+		filteredIndexes = make([]int, len(filters)) // Indexes for filtering.
+		labels          = s.view(query).Labels      // Labels associated with this query.
 	)
 
 	// Filter labels, if needed.
@@ -168,69 +206,143 @@ func (s *Storage) GetLabels(query string, filters []string) []string {
 	return labels
 }
 
-// Gets results based on a start and end timestamp.
+// Get the labels that applied to a result, which may differ from current labels for results from
+// prior executions.
+func (s *Storage) GetResultLabels(query string, result Result) []string {
+	results := s.view(query)
+	return results.labelsFor(result)
+}
+
+// Filters the values of a result, according to the labels that applied to it.
+func (s *Storage) FilterResult(query string, filters []string, result Result) Result {
+	results := s.view(query)
+	return filterResult(query, filters, results.labelsFor(result), result)
+}
+
+// Gets results created before a timestamp. The returned slice must not be modified.
+func (s *Storage) GetBefore(query string, time time.Time) []Result {
+	results := s.view(query)
+	return results.getBefore(time)
+}
+
+// Gets the most recent results, up to a count. The returned slice must not be modified.
+func (s *Storage) GetLatest(query string, count int) []Result {
+	results := s.view(query)
+	return results.getLatest(count)
+}
+
+// Gets results based on a start and end timestamp, inclusive. The returned slice must not be
+// modified.
 func (s *Storage) GetRange(query string, startTime, endTime time.Time) []Result {
-	return (*s).Results[query].getRange(startTime, endTime)
+	results := s.view(query)
+	return results.getRange(startTime, endTime)
+}
+
+// Iterates over filtered results up to a reader index (a.k.a. "playback"), without copying them
+// first. Iteration stops early if the callback returns false. Results put during iteration aren't
+// included.
+func (s *Storage) EachToIndex(
+	query string,
+	filters []string,
+	index *ReaderIndex,
+	f func(result Result) bool,
+) {
+	var (
+		results = s.view(query) // Results to iterate over.
+
+		end = min(int(*index)+1, len(results.Results)) // End of results to read.
+	)
+
+	// Retention may have removed results since the reader index was last updated.
+	for _, result := range results.Results[:max(end, 0)] {
+		if !f(filterResult(query, filters, results.labelsFor(result), result)) {
+			return
+		}
+	}
 }
 
 // Given results up to a reader index (a.k.a. "playback").
 func (s *Storage) GetToIndex(query string, filters []string, index *ReaderIndex) []Result {
 	var (
-		results         = (*s).Results[query].Results[:(*index)+1] // Queried results.
-		filteredResults = make([]Result, len(results))             // Results after filtering.
-		labels          = (*s).Results[query].Labels               // Labels associated with this query.
+		filteredResults = []Result{} // Results after filtering.
 	)
 
-	for i, result := range results {
-		filteredResults[i] = filterResult(query, filters, labels, result)
-	}
+	s.EachToIndex(query, filters, index, func(result Result) bool {
+		filteredResults = append(filteredResults, result)
+		return true
+	})
 
 	return filteredResults
 }
 
+// Lists queries with results, in order.
+func (s *Storage) GetQueries() (queries []string) {
+	(*s).resultsMutex.RLock()
+	defer (*s).resultsMutex.RUnlock()
+
+	queries = make([]string, 0, len((*s).Results))
+	for query := range (*s).Results {
+		queries = append(queries, query)
+	}
+	slices.Sort(queries)
+
+	return
+}
+
 // Given a filter, return the corresponding value index.
 func (s *Storage) GetValueIndex(query, filter string) int {
-	return (*s).Results[query].getValueIndex(filter)
+	results := s.view(query)
+	return results.getValueIndex(filter)
 }
 
 // Initialize a new reader index. Will attempt to set the initial value to the end of existing
 // results, if results already exist.
 func (s *Storage) NewReaderIndex(query string) *ReaderIndex {
 	var (
-		reader ReaderIndex // Reader index to initialize.
+		reader = ReaderIndex(len(s.view(query).Results)) // Reader index to initialize.
 	)
 
-	if _, ok := (*s).Results[query]; !ok {
-		// There is no data.
-		reader = ReaderIndex(0)
-	} else {
-		// There is existing data to account for.
-		reader = ReaderIndex(len((*s).Results[query].Results))
-	}
-
 	return &reader
 }
 
-// Retrieve the next result from a put event channel, blocking if none exists.
-func (s *Storage) Next(query string, filters []string, reader *ReaderIndex) (next Result) {
-	// Read from the event channel.
-	next = <-(*s).putEventChans[query]
+// Retrieve the next result from a subscription, blocking if none exists. Returns an empty result if
+// the subscription has ended.
+func (s *Storage) Next(
+	subscription *Subscription,
+	filters []string,
+	reader *ReaderIndex,
+) (next Result) {
+	var (
+		ok bool // Whether the subscription is still active.
+	)
+
+	// Read from the subscription.
+	if next, ok = <-(*subscription).C; !ok {
+		return
+	}
 	reader.Inc()
 
-	slog.Debug("Received next from channel", "result", next)
+	slog.Debug("Received next from subscription", "result", next)
 
 	// Apply filters.
-	next = filterResult(query, filters, (*s).Results[query].Labels, next)
+	next = filterResult(
+		(*subscription).query, filters, s.GetResultLabels((*subscription).query, next), next)
 
 	return
 }
 
-// Retrieve the next result from a put event channel, returning an empty result if nothing exists.
-func (s *Storage) NextOrEmpty(query string, reader *ReaderIndex) (next Result) {
+// Retrieve the next result from a subscription, returning an empty result if nothing exists.
+func (s *Storage) NextOrEmpty(subscription *Subscription, reader *ReaderIndex) (next Result) {
+	var (
+		ok bool // Whether something was received.
+	)
+
 	select {
-	case next = <-(*s).putEventChans[query]:
+	case next, ok = <-(*subscription).C:
 		// Only increment the read counter if something consumed the event.
-		reader.Inc()
+		if ok {
+			reader.Inc()
+		}
 	default:
 	}
 
@@ -243,77 +355,137 @@ func (s *Storage) Put(
 	persistence bool,
 	values ...interface{},
 ) (result Result, err error) {
-	// Initialize the result.
-	s.newResults(query, len(values))
-	result = (*s).Results[query].put(value, values...)
-
-	slog.Debug(
-		"Storing results",
-		"query",
-		query,
-		"result",
-		result,
-		"labels",
-		(*s).Results[query].Labels,
+	return s.PutResult(query, persistence, Result{Value: value, Values: values})
+}
+
+// Put a new pre-built result, for results that carry more than values (e.g. a status). The result
+// time is assigned if one isn't already present.
+func (s *Storage) PutResult(query string, persistence bool, next Result) (result Result, err error) {
+	var (
+		labels []string // Labels of the result.
 	)
 
-	if result.IsEmptyValues() {
-		slog.Warn("Storing empty result", "query", query)
+	// Results are stored and persisted together, so that the order of persisted results matches the
+	// order they were stored in and snapshots never capture a result that is then logged again.
+	persistence = persistence && (*s).logFile != nil
+	if persistence {
+		(*s).storageMutex.Lock()
 	}
 
-	// Send a non-blocking put event. Put events are lossy and clients may lose information if not
-	// actively listening.
-	select {
-	case (*s).putEventChans[query] <- result:
-	default:
+	// Initialize the result.
+	(*s).resultsMutex.Lock()
+	s.newResults(query, len(next.Values))
+	result = (*s).Results[query].putResult(next)
+	if policy, ok := (*s).retention[query]; ok {
+		(*s).Results[query].retain(policy, result.Time)
+	}
+	labels = (*s).Results[query].labelsFor(result)
+	(*s).resultsMutex.Unlock()
+
+	slog.Debug("Storing results", "query", query, "result", result, "labels", labels)
+
+	if result.IsEmptyValues() && result.Status == RESULT_STATUS_OK {
+		slog.Warn("Storing empty result", "query", query)
 	}
 
 	// Persist data to disk.
 	if persistence {
-		err = s.save()
+		err = s.persist(record{Query: query, Result: &result})
+		(*s).storageMutex.Unlock()
 	}
 	if err != nil {
 		return
 	}
 
-	// Persist data to external sources.
+	// Send the result to subscribers.
+	s.publish(query, result)
+
+	// Persist data to external sources. Each receives the result, regardless of others failing.
 	for _, externalStore := range (*s).externalStorages {
-		err = externalStore.Put(query, (*s).Results[query].Labels, result)
-		if err != nil {
-			return
-		}
+		err = errors.Join(err, externalStore.Put(query, labels, result))
+	}
+
+	return
+}
+
+// Assigns explicit labels to a results series. Labels are persisted alongside results (if storage is
+// persisted) and apply to results from this point onward. Assigning the current labels again does
+// nothing.
+func (s *Storage) PutLabels(query string, labels []string) (err error) {
+	return s.PutLabelSchema(query, LabelSchema{Labels: labels, Since: time.Now()})
+}
+
+// Assigns explicit labels to a results series from a specific point in time, such as when labels
+// are copied from elsewhere. See `PutLabels`.
+func (s *Storage) PutLabelSchema(query string, schema LabelSchema) (err error) {
+	if (*s).logFile != nil {
+		(*s).storageMutex.Lock()
+		defer (*s).storageMutex.Unlock()
+	}
+
+	(*s).resultsMutex.Lock()
+	s.newResults(query, len(schema.Labels))
+	if len((*s).Results[query].LabelSchemas) > 0 &&
+		slices.Equal((*s).Results[query].Labels, schema.Labels) {
+		(*s).resultsMutex.Unlock()
+		return
+	}
+	(*s).Results[query].putLabels(schema)
+	(*s).resultsMutex.Unlock()
+
+	// Persist data to disk.
+	if (*s).logFile != nil {
+		err = s.persist(record{Query: query, Labels: &schema})
 	}
 
 	return
 }
 
-// Assigns explicit labels to a results series.
-func (s *Storage) PutLabels(query string, labels []string) {
-	s.newResults(query, len(labels))
-	(*s).Results[query].Labels = labels
+// Assigns retention policies to results series, enforcing them immediately and as results are added
+// afterwards. If enforcement changes persisted results, storage is compacted once to reflect it.
+func (s *Storage) PutRetention(policies map[string]RetentionPolicy) (err error) {
+	var (
+		changed bool // Whether enforcement changed results.
+	)
+
+	(*s).resultsMutex.Lock()
+	for query, policy := range policies {
+		s.newResults(query, 0)
+		(*s).retention[query] = policy
+		changed = (*s).Results[query].retain(policy, time.Now()) || changed
+	}
+	(*s).resultsMutex.Unlock()
+
+	if changed && (*s).logFile != nil {
+		(*s).storageMutex.Lock()
+		defer (*s).storageMutex.Unlock()
+		err = s.snapshot()
+	}
+
+	return
 }
 
 // Show all currently stored results.
 func (s *Storage) Show(query string) {
-	(*s).Results[query].show()
+	results := s.view(query)
+	results.show()
 }
 
 // Initializes a new storage, loading in any saved storage data.
-func NewStorage(persistence bool) (storage Storage, err error) {
+func NewStorage(persistence bool, syncPolicy SyncPolicy) (storage Storage, err error) {
 	var (
-		shuiUserCacheDir string              // Shui specific user cache data.
-		results          map[string]*Results // Pre-built storage with existing data.
-		storageData      []byte              // Raw read storage data.
-		storageFilepath  string              // Filepath for storage.
-		storageStat      fs.FileInfo         // Stat for the storage file.
-		userCacheDir     string              // User cache directory, contextual to OS.
+		userCacheDir string // User cache directory, contextual to OS.
 	)
 
 	// Initialize storage.
 	storage = Storage{
-		Results:       make(map[string]*Results, MAX_RESULTS),
-		putEventChans: make(map[string](chan Result), MAX_RESULTS),
-		storageMutex:  &sync.Mutex{},
+		Results:            make(map[string]*Results, MAX_RESULTS),
+		resultsMutex:       &sync.RWMutex{},
+		retention:          make(map[string]RetentionPolicy, MAX_RESULTS),
+		storageMutex:       &sync.Mutex{},
+		subscriptions:      make(map[string][]*Subscription, MAX_RESULTS),
+		subscriptionsMutex: &sync.RWMutex{},
+		syncPolicy:         syncPolicy,
 	}
 
 	// If we have disabled persistence, simply return the new storage instance.
@@ -328,43 +500,14 @@ func NewStorage(persistence bool) (storage Storage, err error) {
 	}
 
 	// Create the user cache directory for data.
-	shuiUserCacheDir = filepath.Join(userCacheDir, STORAGE_FILE_DIR)
-	err = os.MkdirAll(shuiUserCacheDir, fs.FileMode(0770))
+	storage.storageDir = filepath.Join(userCacheDir, STORAGE_FILE_DIR)
+	err = os.MkdirAll(storage.storageDir, fs.FileMode(0770))
 	if err != nil {
 		return
 	}
 
-	// Instantiate the storage file.
-	storageFilepath = filepath.Join(shuiUserCacheDir, STORAGE_FILE_NAME)
-	storage.storageFile, err = os.OpenFile(
-		storageFilepath,
-		os.O_CREATE|os.O_RDWR,
-		fs.FileMode(0770),
-	)
-	if err != nil {
-		return
-	}
-
-	if storageStat, err = os.Stat(storageFilepath); storageStat.Size() > 0 {
-		// There is pre-existing storage data.
-		if err != nil {
-			return
-		}
-
-		// Read in storage data and supply it to storage. We must initialize any results series before
-		// populating data.
-		storageData, err = io.ReadAll(storage.storageFile)
-		if err != nil {
-			return
-		}
-		json.Unmarshal(storageData, &results)
-
-		for query := range results {
-			// TODO Results loading should also preserve and restore actual labels.
-			storage.newResults(query, len(results[query].Results[0].Values))
-			storage.Results[query] = results[query]
-		}
-	}
+	// Load pre-existing storage data.
+	err = storage.load()
 
 	return
 }
@@ -375,13 +518,68 @@ func NewStorage(persistence bool) (storage Storage, err error) {
 //
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 
-type ArgsRPC struct{}
+// Arguments for RPC calls.
+type ArgsRPC struct {
+	Query              string    // Query to retrieve results for.
+	Since              time.Time // Time after which to follow results.
+	StartTime, EndTime time.Time // Time range to retrieve results for.
+}
 
+// Reply for RPC calls.
 type ResultsRPC struct {
-	Results *Results
+	LabelSchemas []LabelSchema // Label schemas of the query.
+	Queries      []string      // Queries with results.
+	Results      []Result      // Retrieved results.
+}
+
+// Exposes storage over RPC. Only methods meant to be called remotely are exported.
+type StorageRPC struct {
+	storage *Storage // Storage to expose.
 }
 
-func (r *Results) GetAllRPC(args *ArgsRPC, results *ResultsRPC) error {
-	results.Results = r
+// Waits for results put after a time, returning them once any exist. Returns no results if none
+// arrive before the follow timeout, in which case clients are expected to call again.
+func (s *StorageRPC) Follow(args *ArgsRPC, reply *ResultsRPC) error {
+	var (
+		// Subscribing happens before looking for results, so that nothing is put unseen in between. The
+		// subscription is only used as a signal that results have been put.
+		subscription = (*s).storage.Subscribe((*args).Query, 1, DROP_POLICY_NEWEST)
+		timer        = time.NewTimer(RPC_FOLLOW_TIMEOUT)
+	)
+	defer (*s).storage.Unsubscribe(subscription)
+	defer timer.Stop()
+
+	for {
+		(*reply).LabelSchemas = (*s).storage.GetLabelSchemas((*args).Query)
+		(*reply).Results = (*s).storage.GetAfter((*args).Query, (*args).Since)
+		if len((*reply).Results) > 0 {
+			return nil
+		}
+
+		select {
+		case <-(*subscription).C:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// Retrieves results based on a start and end timestamp, inclusive.
+func (s *StorageRPC) GetRange(args *ArgsRPC, reply *ResultsRPC) error {
+	(*reply).LabelSchemas = (*s).storage.GetLabelSchemas((*args).Query)
+	(*reply).Results = (*s).storage.GetRange((*args).Query, (*args).StartTime, (*args).EndTime)
+
 	return nil
 }
+
+// Lists queries with results.
+func (s *StorageRPC) Queries(args *ArgsRPC, reply *ResultsRPC) error {
+	(*reply).Queries = (*s).storage.GetQueries()
+
+	return nil
+}
+
+// Prepares storage to be exposed over RPC.
+func NewStorageRPC(storage *Storage) *StorageRPC {
+	return &StorageRPC{storage: storage}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// External storage counting results, optionally failing to store them.
+type testExternalStorage struct {
+	err  error
+	puts int
+}
+
+func (e *testExternalStorage) Put(query string, labels []string, result Result) error {
+	(*e).puts++
+	return (*e).err
+}
+
+func TestStoragePutResultExternal(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	failing := &testExternalStorage{err: errors.New("foo")}
+	working := &testExternalStorage{}
+	storage.AddExternalStorage(failing)
+	storage.AddExternalStorage(working)
+
+	// It puts results to every external storage, reporting those that fail.
+	if _, err := storage.Put("fizz", "1", false, int64(1)); !errors.Is(err, (*failing).err) {
+		t.Errorf("Got: %v Expected: %v\n", err, (*failing).err)
+	}
+	if (*failing).puts != 1 || (*working).puts != 1 {
+		t.Errorf("Got: %v Expected: %v\n", []int{(*failing).puts, (*working).puts}, []int{1, 1})
+	}
+}
+
+func TestStorageEachToIndex(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	storage.PutLabels("foo", []string{"fizz", "buzz"})
+	for i := 1; i <= 3; i++ {
+		storage.Put("foo", "", false, int64(i), int64(i*10))
+	}
+
+	// It iterates over filtered results up to the reader index.
+	var got []Values
+	reader := ReaderIndex(1)
+	storage.EachToIndex("foo", []string{"buzz"}, &reader, func(result Result) bool {
+		got = append(got, result.Values)
+		return true
+	})
+	expected := []Values{{int64(10)}, {int64(20)}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v\n", got, expected)
+	}
+
+	// It stops iterating when asked to.
+	got = nil
+	reader = ReaderIndex(2)
+	storage.EachToIndex("foo", []string{}, &reader, func(result Result) bool {
+		got = append(got, result.Values)
+		return false
+	})
+	if len(got) != 1 {
+		t.Errorf("Got: %v Expected: %v\n", len(got), 1)
+	}
+}
+
+func TestFilterResultRows(t *testing.T) {
+	result := Result{
+		Values: Values{"foo", int64(1)},
+		Rows:   []Values{{"foo", int64(1)}, {"bar", int64(2)}},
+	}
+
+	// It filters every row of values.
+	got := filterResult("foo", []string{"buzz"}, []string{"fizz", "buzz"}, result)
+	expected := []Values{{int64(1)}, {int64(2)}}
+	if !reflect.DeepEqual(got.Rows, expected) || !reflect.DeepEqual(got.Values, expected[0]) {
+		t.Errorf("Got: %v Expected: %v\n", got.Rows, expected)
+	}
+}
+
+func TestStorageRPC(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	storage.PutLabels("foo", []string{"fizz"})
+	first, _ := storage.Put("foo", "1", false, int64(1))
+	storage.Put("bar", "2", false, int64(2))
+
+	server := rpc.NewServer()
+	server.RegisterName("Storage", NewStorageRPC(&storage))
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	// It lists queries.
+	var reply ResultsRPC
+	client.Call("Storage.Queries", ArgsRPC{}, &reply)
+	if expected := []string{"bar", "foo"}; !reflect.DeepEqual(reply.Queries, expected) {
+		t.Errorf("Got: %v Expected: %v\n", reply.Queries, expected)
+	}
+
+	// It gets results and labels in a time range.
+	reply = ResultsRPC{}
+	client.Call("Storage.GetRange", ArgsRPC{Query: "foo", EndTime: time.Now()}, &reply)
+	if len(reply.Results) != 1 || reply.Results[0].Values[0] != int64(1) {
+		t.Errorf("Got: %v\n", reply.Results)
+	}
+	if len(reply.LabelSchemas) != 1 || reply.LabelSchemas[0].Labels[0] != "fizz" {
+		t.Errorf("Got: %v\n", reply.LabelSchemas)
+	}
+
+	// It waits for results to follow.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		storage.Put("foo", "3", false, int64(3))
+	}()
+	reply = ResultsRPC{}
+	client.Call("Storage.Follow", ArgsRPC{Query: "foo", Since: first.Time}, &reply)
+	if len(reply.Results) != 1 || reply.Results[0].Values[0] != int64(3) {
+		t.Errorf("Got: %v\n", reply.Results)
+	}
+}
@@ -0,0 +1,159 @@
+//
+// Subscriptions to results.
+//
+// Any number of subscribers may listen for results put to a results series. Each subscriber has its
+// own buffer and decides what happens when that buffer is full, so a slow subscriber only affects
+// others if it asks to block.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy for handling results published to a subscriber whose buffer is full.
+type DropPolicy int
+
+// Fetches a common name from a drop policy value.
+func (p DropPolicy) String() string {
+	return DropPolicies[p]
+}
+
+// Drop policy constants.
+const (
+	DROP_POLICY_NEWEST DropPolicy = iota // Drop the published result. First to serve as the 'default.'
+	DROP_POLICY_OLDEST                   // Drop the oldest buffered result to make room.
+	DROP_POLICY_BLOCK                    // Wait for room, blocking whoever is putting results.
+)
+
+var (
+	// Mapping of drop policy constants to a common drop policy name.
+	DropPolicies = map[DropPolicy]string{
+		DROP_POLICY_NEWEST: "newest",
+		DROP_POLICY_OLDEST: "oldest",
+		DROP_POLICY_BLOCK:  "block",
+	}
+)
+
+// Subscription to a results series.
+type Subscription struct {
+	C <-chan Result // Published results. Closed after unsubscribing.
+
+	done       chan struct{} // Closed when unsubscribing, releasing any blocked publishers.
+	doneOnce   *sync.Once    // Guards closing done.
+	dropPolicy DropPolicy    // What to do when the buffer is full.
+	dropped    atomic.Int64  // Number of results dropped.
+	events     chan Result   // Buffer of published results.
+	query      string        // Query subscribed to.
+}
+
+// Number of results this subscriber has missed because its buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return (*s).dropped.Load()
+}
+
+// Query this subscription is for.
+func (s *Subscription) Query() string {
+	return (*s).query
+}
+
+// Sends a result to the subscriber according to its drop policy.
+func (s *Subscription) publish(result Result) {
+	switch (*s).dropPolicy {
+	case DROP_POLICY_BLOCK:
+		select {
+		case (*s).events <- result:
+		case <-(*s).done:
+		}
+	case DROP_POLICY_OLDEST:
+		for {
+			select {
+			case (*s).events <- result:
+				return
+			default:
+			}
+
+			// Make room, unless the subscriber has already done so.
+			select {
+			case <-(*s).events:
+				(*s).dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case (*s).events <- result:
+		default:
+			(*s).dropped.Add(1)
+		}
+	}
+}
+
+// Sends a result to all subscribers of a query.
+func (s *Storage) publish(query string, result Result) {
+	(*s).subscriptionsMutex.RLock()
+	defer (*s).subscriptionsMutex.RUnlock()
+
+	for _, subscription := range (*s).subscriptions[query] {
+		subscription.publish(result)
+	}
+}
+
+// Subscribes to results put to a query from this point onward. Results are buffered up to a size,
+// after which the drop policy applies. Buffers hold at least one result, since dropping the oldest
+// needs something to drop. Subscribers should unsubscribe when they are done.
+func (s *Storage) Subscribe(query string, size int, dropPolicy DropPolicy) *Subscription {
+	var (
+		events = make(chan Result, max(size, 1)) // Buffer of published results.
+	)
+
+	subscription := &Subscription{
+		C:          events,
+		done:       make(chan struct{}),
+		doneOnce:   &sync.Once{},
+		dropPolicy: dropPolicy,
+		events:     events,
+		query:      query,
+	}
+
+	(*s).subscriptionsMutex.Lock()
+	defer (*s).subscriptionsMutex.Unlock()
+	(*s).subscriptions[query] = append((*s).subscriptions[query], subscription)
+
+	return subscription
+}
+
+// Stops a subscription, closing its channel. Unsubscribing more than once does nothing.
+func (s *Storage) Unsubscribe(subscription *Subscription) {
+	// Release any publisher blocked on this subscriber before waiting on them.
+	(*subscription).doneOnce.Do(func() {
+		close((*subscription).done)
+
+		(*s).subscriptionsMutex.Lock()
+		defer (*s).subscriptionsMutex.Unlock()
+		(*s).subscriptions[(*subscription).query] = slices.DeleteFunc(
+			(*s).subscriptions[(*subscription).query],
+			func(other *Subscription) bool { return other == subscription },
+		)
+		if len((*s).subscriptions[(*subscription).query]) == 0 {
+			delete((*s).subscriptions, (*subscription).query)
+		}
+
+		close((*subscription).events)
+	})
+}
+
+// Fetches a drop policy value from its common name.
+func DropPolicyFromString(s string) (DropPolicy, error) {
+	for k, v := range DropPolicies {
+		if s == v {
+			return k, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Unknown drop policy %s", s))
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorageSubscribe(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+
+	// It sends each result to every subscriber.
+	first := storage.Subscribe("foo", 1, DROP_POLICY_NEWEST)
+	second := storage.Subscribe("foo", 1, DROP_POLICY_NEWEST)
+	other := storage.Subscribe("bar", 1, DROP_POLICY_NEWEST)
+	storage.Put("foo", "1", false, int64(1))
+	for _, subscription := range []*Subscription{first, second} {
+		if got := <-subscription.C; got.Values[0] != int64(1) {
+			t.Errorf("Got: %v Expected: %v\n", got.Values[0], int64(1))
+		}
+	}
+
+	// It doesn't send results for other queries.
+	select {
+	case got := <-other.C:
+		t.Errorf("Got: %v\n", got)
+	default:
+	}
+
+	// It stops sending results after unsubscribing.
+	storage.Unsubscribe(first)
+	storage.Unsubscribe(first)
+	storage.Put("foo", "2", false, int64(2))
+	if got, ok := <-first.C; ok {
+		t.Errorf("Got: %v\n", got)
+	}
+	if got := <-second.C; got.Values[0] != int64(2) {
+		t.Errorf("Got: %v Expected: %v\n", got.Values[0], int64(2))
+	}
+}
+
+func TestStorageSubscribeDropPolicies(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	newest := storage.Subscribe("foo", 1, DROP_POLICY_NEWEST)
+	oldest := storage.Subscribe("foo", 1, DROP_POLICY_OLDEST)
+	for i := 1; i <= 3; i++ {
+		storage.Put("foo", "", false, int64(i))
+	}
+
+	// It keeps the first results when dropping the newest.
+	if got := <-newest.C; got.Values[0] != int64(1) || newest.Dropped() != 2 {
+		t.Errorf("Got: %v, %v dropped\n", got.Values[0], newest.Dropped())
+	}
+
+	// It keeps the latest results when dropping the oldest.
+	if got := <-oldest.C; got.Values[0] != int64(3) || oldest.Dropped() != 2 {
+		t.Errorf("Got: %v, %v dropped\n", got.Values[0], oldest.Dropped())
+	}
+}
+
+func TestStorageSubscribeUnbuffered(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	oldest := storage.Subscribe("foo", 0, DROP_POLICY_OLDEST)
+
+	// It buffers at least one result, rather than spinning on a full buffer.
+	done := make(chan bool)
+	go func() {
+		storage.Put("foo", "1", false, int64(1))
+		storage.Put("foo", "2", false, int64(2))
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Put did not return")
+	}
+	if got := <-oldest.C; got.Values[0] != int64(2) || oldest.Dropped() != 1 {
+		t.Errorf("Got: %v, %v dropped\n", got.Values[0], oldest.Dropped())
+	}
+}
+
+func TestStorageSubscribeBlock(t *testing.T) {
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	blocking := storage.Subscribe("foo", 1, DROP_POLICY_BLOCK)
+	storage.Put("foo", "1", false, int64(1))
+
+	// It blocks puts until the subscriber has room.
+	done := make(chan bool)
+	go func() {
+		storage.Put("foo", "2", false, int64(2))
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Error("Put did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-blocking.C
+	<-done
+
+	// It releases blocked puts when unsubscribing.
+	go func() {
+		storage.Put("foo", "3", false, int64(3))
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	storage.Unsubscribe(blocking)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Put remained blocked")
+	}
+}
+
+func TestStorageConcurrency(t *testing.T) {
+	var wg sync.WaitGroup
+
+	storage, _ := NewStorage(false, SYNC_POLICY_NEVER)
+	storage.PutRetention(map[string]RetentionPolicy{"foo": {MaxCount: 50}})
+
+	// It tolerates concurrent puts, reads, and subscriptions across queries.
+	for _, query := range []string{"foo", "bar", "fizz"} {
+		wg.Add(2)
+		go func(query string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				storage.Put(query, "", false, int64(i))
+			}
+		}(query)
+		go func(query string) {
+			defer wg.Done()
+			subscription := storage.Subscribe(query, 10, DROP_POLICY_OLDEST)
+			defer storage.Unsubscribe(subscription)
+			for i := 0; i < 100; i++ {
+				reader := ReaderIndex(i)
+				storage.GetToIndex(query, []string{}, &reader)
+				storage.GetLatest(query, 10)
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	if got := len(storage.GetAll("bar")); got != 100 {
+		t.Errorf("Got: %v Expected: %v\n", got, 100)
+	}
+	if got := len(storage.GetAll("foo")); got != 50 {
+		t.Errorf("Got: %v Expected: %v\n", got, 50)
+	}
+}
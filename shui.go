@@ -59,6 +59,7 @@ func QueryModeFromString(s string) (QueryMode, error) {
 func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	var (
 		doneQueriesChan chan bool            // Channel for tracking query completion.
+		err             error                // General error holder.
 		pauseQueryChans map[string]chan bool // Channels for pausing queries.
 
 		resultsReadyChan = make(chan bool) // Channel for signaling results readiness.
@@ -81,8 +82,9 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 			lib.QUERY_MODE_STDIN,
 			-1, // Stdin mode is always continuous and the query itself must detect EOF.
 			config.Delay,
+			config.Timeout,
 			config.Queries,
-			config.Port,
+			config.QueryConfigs,
 			config.History,
 			resultsReadyChan,
 		)
@@ -96,8 +98,9 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 			lib.QUERY_MODE_PROFILE,
 			config.Count,
 			config.Delay,
+			config.Timeout,
 			config.Queries,
-			config.Port,
+			config.QueryConfigs,
 			config.History,
 			resultsReadyChan,
 		)
@@ -111,8 +114,9 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 			lib.QUERY_MODE_COMMAND,
 			config.Count,
 			config.Delay,
+			config.Timeout,
 			config.Queries,
-			config.Port,
+			config.QueryConfigs,
 			config.History,
 			resultsReadyChan,
 		)
@@ -122,8 +126,27 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	case config.Mode == int(MODE_READ):
 		slog.Debug("Executing in read mode")
 
-	// FIXME Temporarily disabling read mode.
-	// 	done = lib.Read(port)
+		// Results are copied from a remote Shui and shouldn't be mixed with any local history.
+		config.History = false
+
+		network, addr := config.RPCAddr()
+		doneQueriesChan, pauseQueryChans, config.Queries, err = lib.Read(
+			network,
+			addr,
+			config.Queries,
+			resultsReadyChan,
+		)
+		if err != nil {
+			slog.Error("Unable to read remote results", "addr", addr, "err", err)
+			os.Exit(1)
+		}
+		if len(config.Queries) == 0 {
+			slog.Error("No remote results to read", "addr", addr)
+			os.Exit(1)
+		}
+
+		// Labels are copied along with remote results.
+		ctx = context.WithValue(ctx, "labels", []string{})
 	default:
 		slog.Error(fmt.Sprintf("Invalid mode: %d\n", config.Mode))
 		os.Exit(1)
@@ -134,8 +157,10 @@ func Run(config lib.Config, displayConfig lib.DisplayConfig) {
 	ctx = context.WithValue(ctx, "filters", config.Filters)
 	ctx = context.WithValue(ctx, "queries", config.Queries)
 
-	// Execute result viewing.
-	if !config.Silent {
+	// Execute result viewing, or just manage results if nothing is to be displayed.
+	if config.Silent {
+		go lib.Headless(ctx, config.History, &config, resultsReadyChan)
+	} else {
 		go lib.Results(
 			ctx,
 			lib.DisplayMode(config.DisplayMode),